@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import "encoding/json"
+
+// EC2InstanceStateSchemaVersion is the version of the EC2InstanceState schema
+// published in StateDeclarations. Fields may be added within a version;
+// removing or changing the meaning of a field requires a new version.
+const EC2InstanceStateSchemaVersion = "v1"
+
+// EC2InstanceState is the data published in the StateDeclaration of an
+// EC2Instance. Dependent resources refer to its fields by JSON path, e.g.
+// "instanceIDs" or "instances.0.privateIPAddress".
+//
+// Only instances in the desired state are included. Aggregate lists hold
+// one entry per instance in the same order as Instances, skipping
+// instances without a value, except AvailabilityZones and SecurityGroupIDs
+// which hold each distinct value once.
+// +kubebuilder:object:generate=false
+type EC2InstanceState struct {
+	// SchemaVersion is the EC2InstanceStateSchemaVersion the data conforms to
+	SchemaVersion string `json:"schemaVersion"`
+
+	Instances []InstanceState `json:"instances"`
+
+	InstanceIDs        []string `json:"instanceIDs"`
+	InstanceARNs       []string `json:"instanceARNs"`
+	PrivateIPAddresses []string `json:"privateIPAddresses"`
+	PublicIPAddresses  []string `json:"publicIPAddresses"`
+	PrivateDNSNames    []string `json:"privateDNSNames"`
+	PublicDNSNames     []string `json:"publicDNSNames"`
+	AvailabilityZones  []string `json:"availabilityZones"`
+	SecurityGroupIDs   []string `json:"securityGroupIDs"`
+
+	// Raw holds the EC2 API's description of the instances and the
+	// EC2Instance spec. It is only published when enabled on the manager
+	// and is not covered by the schema version.
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+// InstanceState describes a single instance in an EC2InstanceState
+// +kubebuilder:object:generate=false
+type InstanceState struct {
+	InstanceID string `json:"instanceID"`
+
+	// ARN is omitted if the instance's account cannot be determined
+	ARN string `json:"arn,omitempty"`
+
+	// State is the instance state, e.g. running or stopped
+	State string `json:"state"`
+
+	AvailabilityZone string   `json:"availabilityZone,omitempty"`
+	SubnetID         string   `json:"subnetID,omitempty"`
+	VPCID            string   `json:"vpcID,omitempty"`
+	PrivateIPAddress string   `json:"privateIPAddress,omitempty"`
+	PublicIPAddress  string   `json:"publicIPAddress,omitempty"`
+	PrivateDNSName   string   `json:"privateDNSName,omitempty"`
+	PublicDNSName    string   `json:"publicDNSName,omitempty"`
+	SecurityGroupIDs []string `json:"securityGroupIDs,omitempty"`
+	ImageID          string   `json:"imageID,omitempty"`
+	InstanceType     string   `json:"instanceType,omitempty"`
+}
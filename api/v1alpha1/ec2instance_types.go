@@ -21,7 +21,10 @@ import (
 
 	"github.com/kraken-iac/common/types/option"
 	"github.com/kraken-iac/kraken/api/core/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -29,16 +32,346 @@ import (
 
 // EC2InstanceSpec defines the desired state of EC2Instance
 type EC2InstanceSpec struct {
-	ImageID      option.String `json:"imageID"`
-	InstanceType option.String `json:"instanceType"`
-	MaxCount     option.Int    `json:"maxCount"`
-	MinCount     option.Int    `json:"minCount"`
+	// Region is the AWS region instances are launched in. Defaults to the
+	// region configured on the manager. Cannot be changed once set.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// ProviderConfigRef names a ProviderConfig in the same namespace that
+	// supplies the AWS credentials. The manager's credentials are used if
+	// omitted. Cannot be changed once set.
+	// +optional
+	ProviderConfigRef *corev1.LocalObjectReference `json:"providerConfigRef,omitempty"`
+
+	// ImageID is required unless LaunchTemplate is set
+	// +optional
+	ImageID option.String `json:"imageID,omitempty"`
+
+	// InstanceType is required unless LaunchTemplate is set
+	// +optional
+	InstanceType option.String `json:"instanceType,omitempty"`
+
+	// InstanceTypeChangePolicy controls how instances are updated when the
+	// instance type changes. InPlace stops each instance, changes its type
+	// and starts it again; the instance type must be compatible with the
+	// instance. Spot instances and instances with an instance store root
+	// volume are always replaced.
+	// +kubebuilder:validation:Enum=InPlace;Replace
+	// +kubebuilder:default=Replace
+	// +optional
+	InstanceTypeChangePolicy string `json:"instanceTypeChangePolicy,omitempty"`
+
+	// MaxCount is the number of instances to run. It is the replica count of
+	// the scale subresource: scaling sets value, which then takes precedence
+	// over valueFrom. The scale subresource reports no replica count while
+	// only valueFrom is set; status.desiredInstances holds the resolved
+	// value. MinCount is lowered to MaxCount if it is greater.
+	MaxCount option.Int `json:"maxCount"`
+	MinCount option.Int `json:"minCount"`
+
+	// DesiredState is the power state instances are kept in. Stopped and
+	// hibernated instances keep their EBS volumes and still count towards
+	// maxCount. Hibernated requires Hibernation to be enabled.
+	// +kubebuilder:validation:Enum=Running;Stopped;Hibernated
+	// +kubebuilder:default=Running
+	// +optional
+	DesiredState string `json:"desiredState,omitempty"`
+
+	// Schedules override the instance counts or desired state during
+	// recurring time windows. If several windows are open, the first in the
+	// list applies.
+	// +optional
+	Schedules []Schedule `json:"schedules,omitempty"`
+
+	// Hibernation enables hibernation for instances at launch. It cannot be
+	// enabled on existing instances, so they are replaced when it is enabled.
+	// +optional
+	Hibernation bool `json:"hibernation,omitempty"`
+
+	// LaunchTemplate references an existing launch template that instances
+	// are launched from. Fields set inline on the spec override the template.
+	// +optional
+	LaunchTemplate *LaunchTemplateReference `json:"launchTemplate,omitempty"`
+
+	// SubnetID is the subnet all instances are launched into. The VPC is
+	// implied by the subnet. Mutually exclusive with SubnetIDs.
+	// +optional
+	SubnetID option.String `json:"subnetID,omitempty"`
+
+	// SubnetIDs is a list of subnets that instances are spread across.
+	// Mutually exclusive with SubnetID.
+	// +optional
+	SubnetIDs []option.String `json:"subnetIDs,omitempty"`
+
+	// SecurityGroupIDs are the security groups attached to every instance.
+	// Each entry may reference a security group ID published by another
+	// resource.
+	// +optional
+	SecurityGroupIDs []option.String `json:"securityGroupIDs,omitempty"`
+
+	// UserData is passed to instances at launch, typically a cloud-init
+	// script. It may be provided inline or taken from a ConfigMap or Secret
+	// key. It is base64-encoded by the controller.
+	// +optional
+	UserData option.String `json:"userData,omitempty"`
+
+	// KeyName is the name of the EC2 key pair used for SSH access
+	// +optional
+	KeyName option.String `json:"keyName,omitempty"`
+
+	// IAMInstanceProfile is the ARN or name of the instance profile
+	// associated with instances.
+	// +optional
+	IAMInstanceProfile option.String `json:"iamInstanceProfile,omitempty"`
+
+	// InstanceMarketOptions requests instances from a market other than
+	// on-demand, such as spot
+	// +optional
+	InstanceMarketOptions *InstanceMarketOptions `json:"instanceMarketOptions,omitempty"`
+
+	// MetadataOptions configures the instance metadata service. Specs that
+	// leave IMDSv1 enabled are accepted with a warning.
+	// +optional
+	MetadataOptions *MetadataOptions `json:"metadataOptions,omitempty"`
+
+	// BlockDeviceMappings configures the root volume and any additional data
+	// volumes attached to instances. The AMI's defaults are used if omitted.
+	// +optional
+	BlockDeviceMappings *BlockDeviceMappings `json:"blockDeviceMappings,omitempty"`
+
+	// UpdateStrategy controls how instances that no longer match the spec
+	// are replaced. Defaults to a RollingUpdate.
+	// +optional
+	UpdateStrategy *UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// ResyncPeriod is how often instances are re-checked against the spec
+	// once ready, so that terminated or drifted instances are healed. A
+	// small amount of jitter is added. Defaults to the manager's
+	// --resync-period; 0s disables periodic resyncs.
+	// +optional
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+
+	// LaunchTimeout is how long launched instances may take to reach the
+	// running state before they are marked as failed and terminated.
+	// Defaults to the manager's --launch-timeout.
+	// +optional
+	LaunchTimeout *metav1.Duration `json:"launchTimeout,omitempty"`
 
 	// +optional
 	Tags map[string]string `json:"tags,omitempty"`
 }
 
-func (s EC2InstanceSpec) GenerateDependencyRequestSpec() v1alpha1.DependencyRequestSpec {
+const (
+	DesiredStateRunning    string = "Running"
+	DesiredStateStopped    string = "Stopped"
+	DesiredStateHibernated string = "Hibernated"
+)
+
+const (
+	// InPlaceInstanceTypeChangePolicy changes the type of existing instances
+	InPlaceInstanceTypeChangePolicy string = "InPlace"
+	// ReplaceInstanceTypeChangePolicy replaces instances of the old type
+	ReplaceInstanceTypeChangePolicy string = "Replace"
+)
+
+const (
+	// RollingUpdateStrategyType replaces out-of-date instances in batches
+	RollingUpdateStrategyType string = "RollingUpdate"
+	// RecreateStrategyType terminates all out-of-date instances before
+	// launching their replacements
+	RecreateStrategyType string = "Recreate"
+)
+
+// UpdateStrategy defines how out-of-date instances are replaced
+type UpdateStrategy struct {
+	// Type is either RollingUpdate or Recreate
+	// +kubebuilder:validation:Enum=RollingUpdate;Recreate
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// RollingUpdate configures the batch size of a RollingUpdate. Only
+	// valid when Type is RollingUpdate.
+	// +optional
+	RollingUpdate *RollingUpdateStrategy `json:"rollingUpdate,omitempty"`
+}
+
+// RollingUpdateStrategy bounds the number of instances above and below the
+// desired count during a rolling update. Percentages are of maxCount.
+type RollingUpdateStrategy struct {
+	// MaxSurge is the number of instances that may be launched above
+	// maxCount. Percentages are rounded up. Defaults to 25%.
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+
+	// MaxUnavailable is the number of instances below maxCount that may be
+	// unavailable. Percentages are rounded down. Defaults to 25%.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// Schedule overrides the instance counts or desired state while its window is
+// open. The window opens at each time matching Start and closes at the next
+// time matching End.
+type Schedule struct {
+	// Name identifies the schedule in status
+	Name string `json:"name"`
+
+	// Start is a standard five-field cron expression for when the window
+	// opens, e.g. "0 8 * * 1-5"
+	Start string `json:"start"`
+
+	// End is a standard five-field cron expression for when the window
+	// closes, e.g. "0 18 * * 1-5"
+	End string `json:"end"`
+
+	// TimeZone is the IANA time zone the cron expressions are evaluated in,
+	// e.g. Europe/Dublin. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// MaxCount overrides spec.maxCount while the window is open
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxCount *int `json:"maxCount,omitempty"`
+
+	// MinCount overrides spec.minCount while the window is open
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinCount *int `json:"minCount,omitempty"`
+
+	// DesiredState overrides spec.desiredState while the window is open
+	// +kubebuilder:validation:Enum=Running;Stopped;Hibernated
+	// +optional
+	DesiredState string `json:"desiredState,omitempty"`
+}
+
+// LaunchTemplateReference identifies a launch template by ID or name
+type LaunchTemplateReference struct {
+	// ID of the launch template. Mutually exclusive with Name.
+	// +optional
+	ID string `json:"id,omitempty"`
+
+	// Name of the launch template. Mutually exclusive with ID.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Version of the launch template: $Latest, $Default or a version number.
+	// The template's default version is used if omitted.
+	// +kubebuilder:validation:Pattern=`^(\$Latest|\$Default|[0-9]+)$`
+	// +optional
+	Version string `json:"version,omitempty"`
+}
+
+// InstanceMarketOptions defines the market instances are purchased from
+type InstanceMarketOptions struct {
+	// Spot launches instances as spot instances
+	// +optional
+	Spot *SpotMarketOptions `json:"spot,omitempty"`
+}
+
+// SpotMarketOptions defines the options for spot instances
+type SpotMarketOptions struct {
+	// MaxPrice is the maximum hourly price in USD. Defaults to the on-demand
+	// price if omitted.
+	// +optional
+	MaxPrice string `json:"maxPrice,omitempty"`
+
+	// InterruptionBehavior is the behavior when a spot instance is
+	// interrupted. Stop and hibernate require a persistent request.
+	// +kubebuilder:validation:Enum=terminate;stop;hibernate
+	// +optional
+	InterruptionBehavior string `json:"interruptionBehavior,omitempty"`
+
+	// RequestType is either a one-time or persistent spot request
+	// +kubebuilder:validation:Enum=one-time;persistent
+	// +optional
+	RequestType string `json:"requestType,omitempty"`
+}
+
+// MetadataOptions defines the instance metadata service options
+type MetadataOptions struct {
+	// HTTPTokens set to required enforces IMDSv2 session tokens. Optional
+	// also allows IMDSv1 requests.
+	// +kubebuilder:validation:Enum=required;optional
+	// +optional
+	HTTPTokens string `json:"httpTokens,omitempty"`
+
+	// HTTPPutResponseHopLimit is the hop limit for metadata token responses
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=64
+	// +optional
+	HTTPPutResponseHopLimit *int32 `json:"httpPutResponseHopLimit,omitempty"`
+
+	// HTTPEndpoint enables or disables the metadata endpoint
+	// +kubebuilder:validation:Enum=enabled;disabled
+	// +optional
+	HTTPEndpoint string `json:"httpEndpoint,omitempty"`
+
+	// InstanceMetadataTags enables access to instance tags from the metadata
+	// service
+	// +kubebuilder:validation:Enum=enabled;disabled
+	// +optional
+	InstanceMetadataTags string `json:"instanceMetadataTags,omitempty"`
+}
+
+// BlockDeviceMappings defines the EBS volumes attached to instances
+type BlockDeviceMappings struct {
+	// RootVolume overrides the AMI's root volume
+	// +optional
+	RootVolume *EBSVolume `json:"rootVolume,omitempty"`
+
+	// DataVolumes are additional volumes attached to instances
+	// +optional
+	DataVolumes []DataVolume `json:"dataVolumes,omitempty"`
+}
+
+// EBSVolume defines the configuration of an EBS volume
+type EBSVolume struct {
+	// SizeGiB is the size of the volume in GiB
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SizeGiB *int32 `json:"sizeGiB,omitempty"`
+
+	// +kubebuilder:validation:Enum=gp2;gp3;io1;io2;st1;sc1;standard
+	// +optional
+	VolumeType string `json:"volumeType,omitempty"`
+
+	// IOPS is required for io1 and io2 volumes and optional for gp3 volumes
+	// +optional
+	IOPS *int32 `json:"iops,omitempty"`
+
+	// Throughput in MiB/s. Only valid for gp3 volumes.
+	// +optional
+	Throughput *int32 `json:"throughput,omitempty"`
+
+	// +optional
+	Encrypted *bool `json:"encrypted,omitempty"`
+
+	// KMSKeyID is the KMS key used to encrypt the volume. Requires Encrypted.
+	// +optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+
+	// +optional
+	DeleteOnTermination *bool `json:"deleteOnTermination,omitempty"`
+}
+
+// DataVolume is an EBS volume attached at the given device name
+type DataVolume struct {
+	// DeviceName is the device name exposed to the instance, e.g. /dev/sdf
+	DeviceName string `json:"deviceName"`
+
+	EBSVolume `json:",inline"`
+}
+
+// GenerateDependencyRequestSpec returns the DependencyRequest spec that
+// resolves the spec's references. DependencyRequests cannot resolve Secrets,
+// so an error is returned if any field but userData references one.
+func (s EC2InstanceSpec) GenerateDependencyRequestSpec() (v1alpha1.DependencyRequestSpec, error) {
+	if errs := s.secretRefErrors(field.NewPath("spec")); len(errs) > 0 {
+		return v1alpha1.DependencyRequestSpec{}, errs.ToAggregate()
+	}
+
 	dr := v1alpha1.DependencyRequestSpec{}
 	if s.ImageID.ValueFrom != nil {
 		s.ImageID.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
@@ -46,22 +379,264 @@ func (s EC2InstanceSpec) GenerateDependencyRequestSpec() v1alpha1.DependencyRequ
 	if s.InstanceType.ValueFrom != nil {
 		s.InstanceType.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
 	}
-	if s.MaxCount.ValueFrom != nil {
+	// A value set through the scale subresource overrides valueFrom
+	if s.MaxCount.ValueFrom != nil && s.MaxCount.Value == nil {
 		s.MaxCount.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.Int)
 	}
 	if s.MinCount.ValueFrom != nil {
 		s.MinCount.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.Int)
 	}
-	return dr
+	if s.SubnetID.ValueFrom != nil {
+		s.SubnetID.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+	}
+	for _, subnetID := range s.SubnetIDs {
+		if subnetID.ValueFrom != nil {
+			subnetID.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+		}
+	}
+	// Secret references are resolved by the controller rather than through
+	// the DependencyRequest
+	if s.UserData.ValueFrom != nil && s.UserData.ValueFrom.Secret == nil {
+		s.UserData.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+	}
+	if s.KeyName.ValueFrom != nil {
+		s.KeyName.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+	}
+	if s.IAMInstanceProfile.ValueFrom != nil {
+		s.IAMInstanceProfile.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+	}
+	for _, securityGroupID := range s.SecurityGroupIDs {
+		if securityGroupID.ValueFrom != nil {
+			securityGroupID.ValueFrom.AddToDependencyRequestSpec(&dr, reflect.String)
+		}
+	}
+	return dr, nil
 }
 
 // EC2InstanceStatus defines the observed state of EC2Instance
 type EC2InstanceStatus struct {
+	// Conditions include Ready and Progressing, which summarise the
+	// resource, and DependenciesResolved, InstancesLaunched,
+	// InstancesHealthy, StateDeclared and Synced, which report each stage of
+	// reconciliation
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// ObservedGeneration is the generation of the spec most recently
+	// applied by the controller. It is not updated while the spec's
+	// references are being resolved.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// UserDataHash is the SHA-256 hash of the user data last applied to
+	// instances. It is used to detect changes to the user data.
+	// +optional
+	UserDataHash string `json:"userDataHash,omitempty"`
+
+	// DriftedInstances lists the instances found to differ from the spec
+	// during the last reconciliation, and how each was corrected.
+	// +optional
+	DriftedInstances []InstanceDrift `json:"driftedInstances,omitempty"`
+
+	// UpdatedInstances is the number of instances matching the spec
+	// +optional
+	UpdatedInstances int32 `json:"updatedInstances,omitempty"`
+
+	// AvailableInstances is the number of instances that are running and
+	// passing status checks
+	// +optional
+	AvailableInstances int32 `json:"availableInstances,omitempty"`
+
+	// ActiveSchedule is the name of the schedule currently applied, if any
+	// +optional
+	ActiveSchedule string `json:"activeSchedule,omitempty"`
+
+	// NextScheduleTime is when the next schedule window opens or closes
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// PendingLaunch tracks instances that have been launched but have not
+	// yet reached the running state
+	// +optional
+	PendingLaunch *PendingLaunch `json:"pendingLaunch,omitempty"`
+
+	// FailedInstances lists the instances from the most recent launch that
+	// did not reach the running state
+	// +optional
+	FailedInstances []FailedInstance `json:"failedInstances,omitempty"`
+
+	// LaunchBatch counts the launches of instances. It is combined with the
+	// UID and generation to derive launch client tokens.
+	// +optional
+	LaunchBatch int64 `json:"launchBatch,omitempty"`
+
+	// LaunchClientToken is the idempotency token of a launch that has not
+	// yet been recorded. Retried launches reuse it so that they do not
+	// create duplicate instances.
+	// +optional
+	LaunchClientToken string `json:"launchClientToken,omitempty"`
+
+	// LaunchPlacements are the requests of the launch using
+	// LaunchClientToken. A retried launch resends the same requests, as a
+	// client token reused with different parameters is rejected.
+	// +optional
+	LaunchPlacements []LaunchPlacement `json:"launchPlacements,omitempty"`
+
+	// Instances lists the instances managed by the resource, including
+	// those being terminated
+	// +optional
+	Instances []InstanceStatus `json:"instances,omitempty"`
+
+	// DesiredInstances is the number of instances the resource should have:
+	// the resolved maxCount, or the active schedule's maxCount
+	// +optional
+	DesiredInstances int32 `json:"desiredInstances,omitempty"`
+
+	// ReadyInstances is the number of instances in the desired power state
+	// +optional
+	ReadyInstances int32 `json:"readyInstances,omitempty"`
+
+	// PendingInstances is the number of instances that are starting
+	// +optional
+	PendingInstances int32 `json:"pendingInstances,omitempty"`
+
+	// TerminatingInstances is the number of instances that are shutting
+	// down before termination
+	// +optional
+	TerminatingInstances int32 `json:"terminatingInstances,omitempty"`
+
+	// Replicas is the number of running instances, reported by the scale
+	// subresource
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Selector is the label selector form of the tags identifying the
+	// resource's instances, reported by the scale subresource. No pods carry
+	// these labels, so a HorizontalPodAutoscaler can only scale the resource
+	// on Object or External metrics.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Dependencies describes the values referenced by the spec and how they
+	// were resolved
+	// +optional
+	Dependencies *DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// DependencyStatus describes the values referenced by the spec
+type DependencyStatus struct {
+	// DependencyRequestName is the name of the DependencyRequest resolving
+	// configMap and krakenResource references
+	// +optional
+	DependencyRequestName string `json:"dependencyRequestName,omitempty"`
+
+	// Unresolved lists the references whose values are not yet available
+	// +optional
+	Unresolved []ReferencedValue `json:"unresolved,omitempty"`
+
+	// Resolved lists the references and the values applied. Values read
+	// from Secrets are redacted.
+	// +optional
+	Resolved []ReferencedValue `json:"resolved,omitempty"`
+}
+
+// ReferencedValue describes a spec field whose value is referenced
+type ReferencedValue struct {
+	// Field is the path of the field, e.g. spec.subnetIDs[1]
+	Field string `json:"field"`
+
+	// Source describes the reference, e.g. configMap network/subnetID
+	Source string `json:"source"`
+
+	// Value is the resolved value. Values from Secrets are redacted, user
+	// data is recorded as its SHA-256 hash and values longer than 256
+	// characters are truncated.
+	// +optional
+	Value string `json:"value,omitempty"`
+}
+
+// InstanceStatus describes an instance managed by the resource
+type InstanceStatus struct {
+	InstanceID string `json:"instanceID"`
+
+	// State is the instance state, e.g. pending or running
+	State string `json:"state"`
+
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// +optional
+	PrivateIPAddress string `json:"privateIPAddress,omitempty"`
+
+	// +optional
+	PublicIPAddress string `json:"publicIPAddress,omitempty"`
+
+	// +optional
+	PrivateDNSName string `json:"privateDNSName,omitempty"`
+
+	// +optional
+	LaunchTime *metav1.Time `json:"launchTime,omitempty"`
+
+	// +optional
+	ImageID string `json:"imageID,omitempty"`
+
+	// +optional
+	InstanceType string `json:"instanceType,omitempty"`
+}
+
+// PendingLaunch describes a launch that is waiting for its instances to run
+type PendingLaunch struct {
+	InstanceIDs []string `json:"instanceIDs"`
+
+	// LaunchTime is when the instances were launched
+	LaunchTime metav1.Time `json:"launchTime"`
+}
+
+// LaunchPlacement is a request of a launch into a single subnet
+type LaunchPlacement struct {
+	// SubnetID is empty if no subnet is specified
+	// +optional
+	SubnetID string `json:"subnetID,omitempty"`
+
+	MaxCount int32 `json:"maxCount"`
+	MinCount int32 `json:"minCount"`
+}
+
+// FailedInstance describes an instance that did not reach the running state
+type FailedInstance struct {
+	InstanceID string `json:"instanceID"`
+
+	// Reason describes why the instance failed
+	Reason string `json:"reason"`
+}
+
+const (
+	// DriftActionReplaced means the instance was terminated and relaunched
+	DriftActionReplaced string = "Replaced"
+	// DriftActionUpdated means the instance was updated in place
+	DriftActionUpdated string = "Updated"
+)
+
+// InstanceDrift describes how an instance differed from the spec
+type InstanceDrift struct {
+	InstanceID string `json:"instanceID"`
+
+	// Reasons describe each way in which the instance differed from the spec
+	Reasons []string `json:"reasons"`
+
+	// Action is the action taken to correct the drift
+	// +kubebuilder:validation:Enum=Replaced;Updated
+	Action string `json:"action"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.maxCount.value,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.status.desiredInstances`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyInstances`
+//+kubebuilder:printcolumn:name="Available",type=integer,JSONPath=`.status.availableInstances`
+//+kubebuilder:printcolumn:name="Pending",type=integer,JSONPath=`.status.pendingInstances`
+//+kubebuilder:printcolumn:name="Terminating",type=integer,JSONPath=`.status.terminatingInstances`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // EC2Instance is the Schema for the ec2instances API
 type EC2Instance struct {
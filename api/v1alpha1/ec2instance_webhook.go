@@ -17,9 +17,17 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/kraken-iac/common/types/option"
+	"github.com/robfig/cron/v3"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -45,13 +53,16 @@ var _ webhook.Validator = &EC2Instance{}
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *EC2Instance) ValidateCreate() (admission.Warnings, error) {
 	ec2instancelog.Info("validate create", "name", r.Name)
-	return nil, r.validateSpec()
+	return r.specWarnings(), r.validateSpec()
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *EC2Instance) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
 	ec2instancelog.Info("validate update", "name", r.Name)
-	return nil, r.validateSpec()
+	if err := r.validateImmutableFields(old.(*EC2Instance)); err != nil {
+		return r.specWarnings(), err
+	}
+	return r.specWarnings(), r.validateSpec()
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -66,6 +77,62 @@ func (r *EC2Instance) validateSpec() error {
 	optionErrs := r.validateOptionFields()
 	errs = append(errs, optionErrs...)
 
+	secretRefErrs := r.Spec.secretRefErrors(field.NewPath("spec"))
+	errs = append(errs, secretRefErrs...)
+
+	placementErrs := r.validatePlacement()
+	errs = append(errs, placementErrs...)
+
+	launchTemplateErrs := r.validateLaunchTemplate()
+	errs = append(errs, launchTemplateErrs...)
+
+	marketErrs := r.validateInstanceMarketOptions()
+	errs = append(errs, marketErrs...)
+
+	blockDeviceErrs := r.validateBlockDeviceMappings()
+	errs = append(errs, blockDeviceErrs...)
+
+	updateStrategyErrs := r.validateUpdateStrategy()
+	errs = append(errs, updateStrategyErrs...)
+
+	if r.Spec.DesiredState == DesiredStateHibernated && !r.Spec.Hibernation {
+		errs = append(errs, field.Invalid(field.NewPath("spec").Child("desiredState"), r.Spec.DesiredState,
+			"Hibernated requires hibernation to be enabled"))
+	}
+
+	scheduleErrs := r.validateSchedules()
+	errs = append(errs, scheduleErrs...)
+
+	if r.Spec.ResyncPeriod != nil && r.Spec.ResyncPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("spec").Child("resyncPeriod"), r.Spec.ResyncPeriod.Duration.String(),
+			"must not be negative"))
+	}
+	if r.Spec.LaunchTimeout != nil && r.Spec.LaunchTimeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(field.NewPath("spec").Child("launchTimeout"), r.Spec.LaunchTimeout.Duration.String(),
+			"must be greater than 0"))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: "aws.kraken-iac.eoinfennessy.com", Kind: "EC2Instance"},
+		r.Name,
+		errs,
+	)
+}
+
+func (r *EC2Instance) validateImmutableFields(old *EC2Instance) error {
+	var errs field.ErrorList
+	// Instances in the previous region would no longer be managed
+	if r.Spec.Region != old.Spec.Region {
+		errs = append(errs, field.Forbidden(field.NewPath("spec").Child("region"), "region cannot be changed"))
+	}
+	// Nor would instances in the previous account
+	if !reflect.DeepEqual(r.Spec.ProviderConfigRef, old.Spec.ProviderConfigRef) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec").Child("providerConfigRef"), "providerConfigRef cannot be changed"))
+	}
+
 	if len(errs) == 0 {
 		return nil
 	}
@@ -76,19 +143,335 @@ func (r *EC2Instance) validateSpec() error {
 	)
 }
 
+func (r *EC2Instance) specWarnings() admission.Warnings {
+	var warnings admission.Warnings
+	warnings = append(warnings, r.keyNameWarnings()...)
+	warnings = append(warnings, r.metadataOptionsWarnings()...)
+	warnings = append(warnings, r.maxCountWarnings()...)
+	return warnings
+}
+
 func (r *EC2Instance) validateOptionFields() field.ErrorList {
 	var errs field.ErrorList
-	if err := r.Spec.ImageID.Validate(); err != nil {
-		errs = append(errs, field.Invalid(field.NewPath("spec").Child("imageID"), r.Spec.ImageID, err.Error()))
+	// ImageID and InstanceType may be omitted in favour of a launch template
+	if r.Spec.LaunchTemplate == nil || r.Spec.ImageID != (option.String{}) {
+		if err := r.Spec.ImageID.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("imageID"), r.Spec.ImageID, err.Error()))
+		}
 	}
-	if err := r.Spec.InstanceType.Validate(); err != nil {
-		errs = append(errs, field.Invalid(field.NewPath("spec").Child("instanceType"), r.Spec.InstanceType, err.Error()))
+	if r.Spec.LaunchTemplate == nil || r.Spec.InstanceType != (option.String{}) {
+		if err := r.Spec.InstanceType.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("instanceType"), r.Spec.InstanceType, err.Error()))
+		}
 	}
-	if err := r.Spec.MaxCount.Validate(); err != nil {
+	// The scale subresource sets maxCount.value, which may then be set
+	// alongside valueFrom and overrides it
+	maxCount := r.Spec.MaxCount
+	if maxCount.Value != nil && maxCount.ValueFrom != nil {
+		if err := maxCount.ValueFrom.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("maxCount").Child("valueFrom"), maxCount.ValueFrom, err.Error()))
+		}
+		maxCount.ValueFrom = nil
+	}
+	if err := maxCount.Validate(); err != nil {
 		errs = append(errs, field.Invalid(field.NewPath("spec").Child("maxCount"), r.Spec.MaxCount, err.Error()))
 	}
+	if maxCount.Value != nil && *maxCount.Value < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("spec").Child("maxCount").Child("value"), *maxCount.Value, "must not be negative"))
+	}
 	if err := r.Spec.MinCount.Validate(); err != nil {
 		errs = append(errs, field.Invalid(field.NewPath("spec").Child("minCount"), r.Spec.MinCount, err.Error()))
 	}
+	if r.Spec.SubnetID != (option.String{}) {
+		if err := r.Spec.SubnetID.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("subnetID"), r.Spec.SubnetID, err.Error()))
+		}
+	}
+	for i, subnetID := range r.Spec.SubnetIDs {
+		if err := subnetID.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("subnetIDs").Index(i), subnetID, err.Error()))
+		}
+	}
+	if r.Spec.UserData != (option.String{}) {
+		if err := r.Spec.UserData.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("userData"), r.Spec.UserData, err.Error()))
+		}
+	}
+	if r.Spec.KeyName != (option.String{}) {
+		if err := r.Spec.KeyName.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("keyName"), r.Spec.KeyName, err.Error()))
+		}
+	}
+	if r.Spec.IAMInstanceProfile != (option.String{}) {
+		if err := r.Spec.IAMInstanceProfile.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("iamInstanceProfile"), r.Spec.IAMInstanceProfile, err.Error()))
+		}
+	}
+	for i, securityGroupID := range r.Spec.SecurityGroupIDs {
+		if err := securityGroupID.Validate(); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec").Child("securityGroupIDs").Index(i), securityGroupID, err.Error()))
+		}
+	}
+	return errs
+}
+
+// secretRefErrors rejects Secret references on every field but userData.
+// Only user data is resolved from Secrets by the controller; DependencyRequests
+// cannot resolve them. It is also checked by the controller in case the
+// webhook is not deployed.
+func (s EC2InstanceSpec) secretRefErrors(specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	forbid := func(path *field.Path, valueFrom *option.ValueFrom) {
+		if valueFrom != nil && valueFrom.Secret != nil {
+			errs = append(errs, field.Forbidden(path.Child("valueFrom").Child("secret"),
+				"secret references are only supported for userData"))
+		}
+	}
+	forbid(specPath.Child("imageID"), s.ImageID.ValueFrom)
+	forbid(specPath.Child("instanceType"), s.InstanceType.ValueFrom)
+	forbid(specPath.Child("maxCount"), s.MaxCount.ValueFrom)
+	forbid(specPath.Child("minCount"), s.MinCount.ValueFrom)
+	forbid(specPath.Child("subnetID"), s.SubnetID.ValueFrom)
+	for i, subnetID := range s.SubnetIDs {
+		forbid(specPath.Child("subnetIDs").Index(i), subnetID.ValueFrom)
+	}
+	for i, securityGroupID := range s.SecurityGroupIDs {
+		forbid(specPath.Child("securityGroupIDs").Index(i), securityGroupID.ValueFrom)
+	}
+	forbid(specPath.Child("keyName"), s.KeyName.ValueFrom)
+	forbid(specPath.Child("iamInstanceProfile"), s.IAMInstanceProfile.ValueFrom)
 	return errs
 }
+
+func (r *EC2Instance) validatePlacement() field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.SubnetID != (option.String{}) && len(r.Spec.SubnetIDs) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("spec").Child("subnetIDs"), "subnetID and subnetIDs are mutually exclusive"))
+	}
+	return errs
+}
+
+func (r *EC2Instance) validateLaunchTemplate() field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.LaunchTemplate == nil {
+		return errs
+	}
+	path := field.NewPath("spec").Child("launchTemplate")
+	if (r.Spec.LaunchTemplate.ID == "") == (r.Spec.LaunchTemplate.Name == "") {
+		errs = append(errs, field.Invalid(path, r.Spec.LaunchTemplate, "exactly one of id or name must be set"))
+	}
+	// The root device name is looked up from the image, which is not known
+	// when the image comes from the launch template
+	if r.Spec.ImageID == (option.String{}) &&
+		r.Spec.BlockDeviceMappings != nil &&
+		r.Spec.BlockDeviceMappings.RootVolume != nil {
+		errs = append(errs, field.Forbidden(
+			field.NewPath("spec").Child("blockDeviceMappings").Child("rootVolume"),
+			"rootVolume requires imageID to be set when using a launch template",
+		))
+	}
+	return errs
+}
+
+func (r *EC2Instance) validateInstanceMarketOptions() field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.InstanceMarketOptions == nil || r.Spec.InstanceMarketOptions.Spot == nil {
+		return errs
+	}
+	spot := r.Spec.InstanceMarketOptions.Spot
+	path := field.NewPath("spec").Child("instanceMarketOptions").Child("spot")
+	if spot.MaxPrice != "" {
+		if price, err := strconv.ParseFloat(spot.MaxPrice, 64); err != nil || price <= 0 {
+			errs = append(errs, field.Invalid(path.Child("maxPrice"), spot.MaxPrice, "maxPrice must be a positive decimal number"))
+		}
+	}
+	if (spot.InterruptionBehavior == "stop" || spot.InterruptionBehavior == "hibernate") && spot.RequestType != "persistent" {
+		errs = append(errs, field.Invalid(path.Child("requestType"), spot.RequestType,
+			fmt.Sprintf("interruptionBehavior %s requires a persistent request", spot.InterruptionBehavior)))
+	}
+	return errs
+}
+
+func (r *EC2Instance) validateBlockDeviceMappings() field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.BlockDeviceMappings == nil {
+		return errs
+	}
+	path := field.NewPath("spec").Child("blockDeviceMappings")
+
+	if r.Spec.BlockDeviceMappings.RootVolume != nil {
+		errs = append(errs, validateEBSVolume(path.Child("rootVolume"), *r.Spec.BlockDeviceMappings.RootVolume)...)
+	}
+
+	deviceNames := make(map[string]bool)
+	for i, dataVolume := range r.Spec.BlockDeviceMappings.DataVolumes {
+		volumePath := path.Child("dataVolumes").Index(i)
+		if dataVolume.DeviceName == "" {
+			errs = append(errs, field.Required(volumePath.Child("deviceName"), "deviceName must be set for data volumes"))
+		} else if deviceNames[dataVolume.DeviceName] {
+			errs = append(errs, field.Duplicate(volumePath.Child("deviceName"), dataVolume.DeviceName))
+		}
+		deviceNames[dataVolume.DeviceName] = true
+		errs = append(errs, validateEBSVolume(volumePath, dataVolume.EBSVolume)...)
+	}
+	return errs
+}
+
+func validateEBSVolume(path *field.Path, volume EBSVolume) field.ErrorList {
+	var errs field.ErrorList
+	switch volume.VolumeType {
+	case "io1", "io2":
+		if volume.IOPS == nil {
+			errs = append(errs, field.Required(path.Child("iops"), "iops must be set for io1 and io2 volumes"))
+		}
+	case "gp3":
+	default:
+		if volume.IOPS != nil {
+			errs = append(errs, field.Forbidden(path.Child("iops"), "iops can only be set for gp3, io1 and io2 volumes"))
+		}
+	}
+	if volume.Throughput != nil && volume.VolumeType != "gp3" {
+		errs = append(errs, field.Forbidden(path.Child("throughput"), "throughput can only be set for gp3 volumes"))
+	}
+	if volume.KMSKeyID != "" && (volume.Encrypted == nil || !*volume.Encrypted) {
+		errs = append(errs, field.Forbidden(path.Child("kmsKeyID"), "kmsKeyID requires encrypted to be true"))
+	}
+	return errs
+}
+
+func (r *EC2Instance) validateUpdateStrategy() field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.UpdateStrategy == nil || r.Spec.UpdateStrategy.RollingUpdate == nil {
+		return errs
+	}
+	path := field.NewPath("spec").Child("updateStrategy").Child("rollingUpdate")
+	if r.Spec.UpdateStrategy.Type == RecreateStrategyType {
+		errs = append(errs, field.Forbidden(path, "rollingUpdate may not be set when type is Recreate"))
+		return errs
+	}
+
+	rollingUpdate := r.Spec.UpdateStrategy.RollingUpdate
+	surgeErrs := validateIntOrPercent(path.Child("maxSurge"), rollingUpdate.MaxSurge)
+	errs = append(errs, surgeErrs...)
+	unavailableErrs := validateIntOrPercent(path.Child("maxUnavailable"), rollingUpdate.MaxUnavailable)
+	errs = append(errs, unavailableErrs...)
+	if len(surgeErrs) == 0 && len(unavailableErrs) == 0 &&
+		isZeroIntOrPercent(rollingUpdate.MaxSurge) && isZeroIntOrPercent(rollingUpdate.MaxUnavailable) {
+		errs = append(errs, field.Invalid(path.Child("maxUnavailable"), rollingUpdate.MaxUnavailable,
+			"maxUnavailable may not be 0 when maxSurge is 0"))
+	}
+	return errs
+}
+
+func (r *EC2Instance) validateSchedules() field.ErrorList {
+	var errs field.ErrorList
+	names := make(map[string]bool)
+	for i, schedule := range r.Spec.Schedules {
+		path := field.NewPath("spec").Child("schedules").Index(i)
+		if schedule.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "name must be set"))
+		} else if names[schedule.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), schedule.Name))
+		}
+		names[schedule.Name] = true
+
+		if _, err := cron.ParseStandard(schedule.Start); err != nil {
+			errs = append(errs, field.Invalid(path.Child("start"), schedule.Start, err.Error()))
+		}
+		if _, err := cron.ParseStandard(schedule.End); err != nil {
+			errs = append(errs, field.Invalid(path.Child("end"), schedule.End, err.Error()))
+		}
+		if schedule.TimeZone != "" {
+			if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+				errs = append(errs, field.Invalid(path.Child("timeZone"), schedule.TimeZone, "unknown time zone"))
+			}
+		}
+
+		if schedule.MaxCount == nil && schedule.MinCount == nil && schedule.DesiredState == "" {
+			errs = append(errs, field.Required(path, "at least one of maxCount, minCount or desiredState must be set"))
+		}
+		if schedule.MaxCount != nil && schedule.MinCount != nil && *schedule.MinCount > *schedule.MaxCount {
+			errs = append(errs, field.Invalid(path.Child("minCount"), *schedule.MinCount, "minCount must not exceed maxCount"))
+		}
+		if schedule.DesiredState == DesiredStateHibernated && !r.Spec.Hibernation {
+			errs = append(errs, field.Invalid(path.Child("desiredState"), schedule.DesiredState,
+				"Hibernated requires hibernation to be enabled"))
+		}
+	}
+	return errs
+}
+
+func validateIntOrPercent(path *field.Path, value *intstr.IntOrString) field.ErrorList {
+	var errs field.ErrorList
+	if value == nil {
+		return errs
+	}
+	scaled, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true)
+	if err != nil {
+		errs = append(errs, field.Invalid(path, value, "must be an integer or a percentage, e.g. 25%"))
+	} else if scaled < 0 {
+		errs = append(errs, field.Invalid(path, value, "must not be negative"))
+	}
+	return errs
+}
+
+// isZeroIntOrPercent reports whether an explicitly set value is zero. Unset
+// values default to a non-zero percentage.
+func isZeroIntOrPercent(value *intstr.IntOrString) bool {
+	if value == nil {
+		return false
+	}
+	scaled, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true)
+	return err == nil && scaled == 0
+}
+
+// keyNameWarnings warns when a key pair is requested with an empty name, as
+// instances will then not accept SSH key authentication
+func (r *EC2Instance) keyNameWarnings() admission.Warnings {
+	if r.Spec.KeyName.Value != nil && *r.Spec.KeyName.Value == "" {
+		return admission.Warnings{
+			fmt.Sprintf("%s: key pair name is empty; instances will not accept SSH key authentication",
+				field.NewPath("spec").Child("keyName"),
+			),
+		}
+	}
+	return nil
+}
+
+// metadataOptionsWarnings warns when IMDSv1 is left enabled, which is the
+// default unless httpTokens is set to required or the endpoint is disabled.
+// No warning is given if the options are left to a launch template, which
+// may enforce IMDSv2 itself.
+func (r *EC2Instance) metadataOptionsWarnings() admission.Warnings {
+	mo := r.Spec.MetadataOptions
+	if mo == nil && r.Spec.LaunchTemplate != nil {
+		return nil
+	}
+	if mo != nil && (mo.HTTPTokens == "required" || mo.HTTPEndpoint == "disabled") {
+		return nil
+	}
+	return admission.Warnings{
+		fmt.Sprintf("%s: IMDSv1 is enabled; set httpTokens to required to enforce IMDSv2",
+			field.NewPath("spec").Child("metadataOptions"),
+		),
+	}
+}
+
+// maxCountWarnings warns when a value set through the scale subresource
+// overrides valueFrom, and when the scale subresource cannot report the
+// replica count as only valueFrom is set
+func (r *EC2Instance) maxCountWarnings() admission.Warnings {
+	path := field.NewPath("spec").Child("maxCount")
+	switch {
+	case r.Spec.MaxCount.Value != nil && r.Spec.MaxCount.ValueFrom != nil:
+		return admission.Warnings{
+			fmt.Sprintf("%s: value overrides valueFrom; remove value to use valueFrom again", path),
+		}
+	case r.Spec.MaxCount.ValueFrom != nil:
+		return admission.Warnings{
+			fmt.Sprintf("%s: the scale subresource reports no replica count while only valueFrom is set; "+
+				"the resolved value is reported in status.desiredInstances", path),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	"github.com/kraken-iac/common/types/option"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newValidEC2Instance() *EC2Instance {
+	imageID := "ami-123"
+	instanceType := "t3.micro"
+	maxCount := 2
+	minCount := 1
+	return &EC2Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: EC2InstanceSpec{
+			ImageID:         option.String{Value: &imageID},
+			InstanceType:    option.String{Value: &instanceType},
+			MaxCount:        option.Int{Value: &maxCount},
+			MinCount:        option.Int{Value: &minCount},
+			MetadataOptions: &MetadataOptions{HTTPTokens: "required"},
+		},
+	}
+}
+
+// invalidFields returns the fields rejected by a validation error
+func invalidFields(err error) []string {
+	statusErr, ok := err.(*apierrors.StatusError)
+	Expect(ok).Should(BeTrue(), "expected a StatusError, got %v", err)
+	var fields []string
+	for _, cause := range statusErr.Status().Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	return fields
+}
+
+func stringValue(s string) option.String {
+	return option.String{Value: &s}
+}
+
+func configMapRef() *option.ValueFrom {
+	return &option.ValueFrom{ConfigMap: &option.ValueFromConfigMap{Name: "config", Key: "key"}}
+}
+
+func secretRef() *option.ValueFrom {
+	return &option.ValueFrom{Secret: &option.ValueFromSecret{Name: "secret", Key: "key"}}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func intOrStringPtr(s string) *intstr.IntOrString {
+	v := intstr.Parse(s)
+	return &v
+}
+
+var _ = Describe("EC2Instance webhook", func() {
+	DescribeTable("ValidateCreate",
+		func(mutate func(*EC2Instance), expectedFields []string) {
+			r := newValidEC2Instance()
+			mutate(r)
+			_, err := r.ValidateCreate()
+			if len(expectedFields) == 0 {
+				Expect(err).Should(BeNil())
+				return
+			}
+			Expect(err).ShouldNot(BeNil())
+			Expect(invalidFields(err)).Should(ConsistOf(expectedFields))
+		},
+		Entry("accepts a valid spec", func(r *EC2Instance) {}, nil),
+
+		// Option fields
+		Entry("requires imageID", func(r *EC2Instance) {
+			r.Spec.ImageID = option.String{}
+		}, []string{"spec.imageID"}),
+		Entry("requires instanceType", func(r *EC2Instance) {
+			r.Spec.InstanceType = option.String{}
+		}, []string{"spec.instanceType"}),
+		Entry("rejects a negative maxCount", func(r *EC2Instance) {
+			r.Spec.MaxCount.Value = intPtr(-1)
+		}, []string{"spec.maxCount.value"}),
+		Entry("accepts a maxCount value set alongside valueFrom", func(r *EC2Instance) {
+			r.Spec.MaxCount.ValueFrom = configMapRef()
+		}, nil),
+		Entry("rejects an invalid maxCount valueFrom set alongside value", func(r *EC2Instance) {
+			r.Spec.MaxCount.ValueFrom = &option.ValueFrom{}
+		}, []string{"spec.maxCount.valueFrom"}),
+		Entry("requires maxCount", func(r *EC2Instance) {
+			r.Spec.MaxCount = option.Int{}
+		}, []string{"spec.maxCount"}),
+
+		// Secret references
+		Entry("accepts a Secret reference on userData", func(r *EC2Instance) {
+			r.Spec.UserData = option.String{ValueFrom: secretRef()}
+		}, nil),
+		Entry("rejects a Secret reference on imageID", func(r *EC2Instance) {
+			r.Spec.ImageID = option.String{ValueFrom: secretRef()}
+		}, []string{"spec.imageID.valueFrom.secret"}),
+		Entry("rejects a Secret reference on a security group", func(r *EC2Instance) {
+			r.Spec.SecurityGroupIDs = []option.String{stringValue("sg-1"), {ValueFrom: secretRef()}}
+		}, []string{"spec.securityGroupIDs[1].valueFrom.secret"}),
+		Entry("rejects a Secret reference on keyName", func(r *EC2Instance) {
+			r.Spec.KeyName = option.String{ValueFrom: secretRef()}
+		}, []string{"spec.keyName.valueFrom.secret"}),
+
+		// Placement
+		Entry("accepts subnetIDs", func(r *EC2Instance) {
+			r.Spec.SubnetIDs = []option.String{stringValue("subnet-1"), stringValue("subnet-2")}
+		}, nil),
+		Entry("rejects subnetID with subnetIDs", func(r *EC2Instance) {
+			r.Spec.SubnetID = stringValue("subnet-1")
+			r.Spec.SubnetIDs = []option.String{stringValue("subnet-2")}
+		}, []string{"spec.subnetIDs"}),
+
+		// Launch template
+		Entry("accepts a launch template without imageID or instanceType", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{Name: "template"}
+			r.Spec.ImageID = option.String{}
+			r.Spec.InstanceType = option.String{}
+		}, nil),
+		Entry("rejects a launch template with both id and name", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{ID: "lt-123", Name: "template"}
+		}, []string{"spec.launchTemplate"}),
+		Entry("rejects a launch template with neither id nor name", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{Version: "$Latest"}
+		}, []string{"spec.launchTemplate"}),
+		Entry("rejects rootVolume with a launch template and no imageID", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{ID: "lt-123"}
+			r.Spec.ImageID = option.String{}
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{RootVolume: &EBSVolume{SizeGiB: int32Ptr(20)}}
+		}, []string{"spec.blockDeviceMappings.rootVolume"}),
+
+		// Spot
+		Entry("accepts a persistent spot request that stops", func(r *EC2Instance) {
+			r.Spec.InstanceMarketOptions = &InstanceMarketOptions{Spot: &SpotMarketOptions{
+				MaxPrice:             "0.05",
+				InterruptionBehavior: "stop",
+				RequestType:          "persistent",
+			}}
+		}, nil),
+		Entry("rejects a non-numeric maxPrice", func(r *EC2Instance) {
+			r.Spec.InstanceMarketOptions = &InstanceMarketOptions{Spot: &SpotMarketOptions{MaxPrice: "cheap"}}
+		}, []string{"spec.instanceMarketOptions.spot.maxPrice"}),
+		Entry("rejects a zero maxPrice", func(r *EC2Instance) {
+			r.Spec.InstanceMarketOptions = &InstanceMarketOptions{Spot: &SpotMarketOptions{MaxPrice: "0"}}
+		}, []string{"spec.instanceMarketOptions.spot.maxPrice"}),
+		Entry("rejects hibernate interruption on a one-time request", func(r *EC2Instance) {
+			r.Spec.InstanceMarketOptions = &InstanceMarketOptions{Spot: &SpotMarketOptions{
+				InterruptionBehavior: "hibernate",
+				RequestType:          "one-time",
+			}}
+		}, []string{"spec.instanceMarketOptions.spot.requestType"}),
+
+		// Block devices
+		Entry("accepts gp3 volumes with iops and throughput", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{
+				RootVolume: &EBSVolume{VolumeType: "gp3", IOPS: int32Ptr(3000), Throughput: int32Ptr(125)},
+				DataVolumes: []DataVolume{{
+					DeviceName: "/dev/sdf",
+					EBSVolume:  EBSVolume{VolumeType: "io2", IOPS: int32Ptr(1000), Encrypted: boolPtr(true), KMSKeyID: "key"},
+				}},
+			}
+		}, nil),
+		Entry("requires iops for io1 volumes", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{RootVolume: &EBSVolume{VolumeType: "io1"}}
+		}, []string{"spec.blockDeviceMappings.rootVolume.iops"}),
+		Entry("rejects iops for gp2 volumes", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{RootVolume: &EBSVolume{VolumeType: "gp2", IOPS: int32Ptr(100)}}
+		}, []string{"spec.blockDeviceMappings.rootVolume.iops"}),
+		Entry("rejects throughput for io2 volumes", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{RootVolume: &EBSVolume{
+				VolumeType: "io2", IOPS: int32Ptr(1000), Throughput: int32Ptr(125),
+			}}
+		}, []string{"spec.blockDeviceMappings.rootVolume.throughput"}),
+		Entry("rejects kmsKeyID on an unencrypted volume", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{RootVolume: &EBSVolume{KMSKeyID: "key"}}
+		}, []string{"spec.blockDeviceMappings.rootVolume.kmsKeyID"}),
+		Entry("requires a device name for data volumes", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{DataVolumes: []DataVolume{{}}}
+		}, []string{"spec.blockDeviceMappings.dataVolumes[0].deviceName"}),
+		Entry("rejects duplicate data volume device names", func(r *EC2Instance) {
+			r.Spec.BlockDeviceMappings = &BlockDeviceMappings{DataVolumes: []DataVolume{
+				{DeviceName: "/dev/sdf"},
+				{DeviceName: "/dev/sdf"},
+			}}
+		}, []string{"spec.blockDeviceMappings.dataVolumes[1].deviceName"}),
+
+		// Update strategy
+		Entry("accepts a rolling update with percentages", func(r *EC2Instance) {
+			r.Spec.UpdateStrategy = &UpdateStrategy{
+				Type: RollingUpdateStrategyType,
+				RollingUpdate: &RollingUpdateStrategy{
+					MaxSurge:       intOrStringPtr("50%"),
+					MaxUnavailable: intOrStringPtr("0"),
+				},
+			}
+		}, nil),
+		Entry("rejects rollingUpdate with Recreate", func(r *EC2Instance) {
+			r.Spec.UpdateStrategy = &UpdateStrategy{
+				Type:          RecreateStrategyType,
+				RollingUpdate: &RollingUpdateStrategy{MaxSurge: intOrStringPtr("1")},
+			}
+		}, []string{"spec.updateStrategy.rollingUpdate"}),
+		Entry("rejects a maxSurge that is not an integer or percentage", func(r *EC2Instance) {
+			r.Spec.UpdateStrategy = &UpdateStrategy{RollingUpdate: &RollingUpdateStrategy{MaxSurge: intOrStringPtr("many")}}
+		}, []string{"spec.updateStrategy.rollingUpdate.maxSurge"}),
+		Entry("rejects a negative maxUnavailable", func(r *EC2Instance) {
+			r.Spec.UpdateStrategy = &UpdateStrategy{RollingUpdate: &RollingUpdateStrategy{MaxUnavailable: intOrStringPtr("-1")}}
+		}, []string{"spec.updateStrategy.rollingUpdate.maxUnavailable"}),
+		Entry("rejects maxSurge and maxUnavailable both 0", func(r *EC2Instance) {
+			r.Spec.UpdateStrategy = &UpdateStrategy{RollingUpdate: &RollingUpdateStrategy{
+				MaxSurge:       intOrStringPtr("0%"),
+				MaxUnavailable: intOrStringPtr("0"),
+			}}
+		}, []string{"spec.updateStrategy.rollingUpdate.maxUnavailable"}),
+
+		// Hibernation
+		Entry("accepts Hibernated with hibernation enabled", func(r *EC2Instance) {
+			r.Spec.DesiredState = DesiredStateHibernated
+			r.Spec.Hibernation = true
+		}, nil),
+		Entry("rejects Hibernated without hibernation", func(r *EC2Instance) {
+			r.Spec.DesiredState = DesiredStateHibernated
+		}, []string{"spec.desiredState"}),
+
+		// Schedules
+		Entry("accepts a valid schedule", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{
+				Name:     "office-hours",
+				Start:    "0 8 * * 1-5",
+				End:      "0 18 * * 1-5",
+				TimeZone: "Europe/Dublin",
+				MaxCount: intPtr(4),
+				MinCount: intPtr(2),
+			}}
+		}, nil),
+		Entry("requires a schedule name", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{Start: "0 8 * * *", End: "0 18 * * *", MaxCount: intPtr(1)}}
+		}, []string{"spec.schedules[0].name"}),
+		Entry("rejects duplicate schedule names", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{
+				{Name: "a", Start: "0 8 * * *", End: "0 18 * * *", MaxCount: intPtr(1)},
+				{Name: "a", Start: "0 8 * * *", End: "0 18 * * *", MaxCount: intPtr(1)},
+			}
+		}, []string{"spec.schedules[1].name"}),
+		Entry("rejects invalid cron expressions", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{Name: "a", Start: "at eight", End: "0 18 * * * *", MaxCount: intPtr(1)}}
+		}, []string{"spec.schedules[0].start", "spec.schedules[0].end"}),
+		Entry("rejects an unknown time zone", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{
+				Name: "a", Start: "0 8 * * *", End: "0 18 * * *", TimeZone: "Mars/Olympus", MaxCount: intPtr(1),
+			}}
+		}, []string{"spec.schedules[0].timeZone"}),
+		Entry("rejects a schedule without an override", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{Name: "a", Start: "0 8 * * *", End: "0 18 * * *"}}
+		}, []string{"spec.schedules[0]"}),
+		Entry("rejects a schedule minCount above maxCount", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{
+				Name: "a", Start: "0 8 * * *", End: "0 18 * * *", MaxCount: intPtr(1), MinCount: intPtr(2),
+			}}
+		}, []string{"spec.schedules[0].minCount"}),
+		Entry("rejects a Hibernated schedule without hibernation", func(r *EC2Instance) {
+			r.Spec.Schedules = []Schedule{{
+				Name: "a", Start: "0 18 * * *", End: "0 8 * * *", DesiredState: DesiredStateHibernated,
+			}}
+		}, []string{"spec.schedules[0].desiredState"}),
+
+		// Timeouts
+		Entry("accepts a resyncPeriod of 0", func(r *EC2Instance) {
+			r.Spec.ResyncPeriod = &metav1.Duration{}
+		}, nil),
+		Entry("rejects a negative resyncPeriod", func(r *EC2Instance) {
+			r.Spec.ResyncPeriod = &metav1.Duration{Duration: -time.Minute}
+		}, []string{"spec.resyncPeriod"}),
+		Entry("rejects a launchTimeout of 0", func(r *EC2Instance) {
+			r.Spec.LaunchTimeout = &metav1.Duration{}
+		}, []string{"spec.launchTimeout"}),
+	)
+
+	DescribeTable("ValidateUpdate",
+		func(mutateOld func(*EC2Instance), mutate func(*EC2Instance), expectedFields []string) {
+			old := newValidEC2Instance()
+			mutateOld(old)
+			r := newValidEC2Instance()
+			mutateOld(r)
+			mutate(r)
+			_, err := r.ValidateUpdate(old)
+			if len(expectedFields) == 0 {
+				Expect(err).Should(BeNil())
+				return
+			}
+			Expect(err).ShouldNot(BeNil())
+			Expect(invalidFields(err)).Should(ConsistOf(expectedFields))
+		},
+		Entry("accepts a change to a mutable field",
+			func(r *EC2Instance) { r.Spec.Region = "eu-west-1" },
+			func(r *EC2Instance) { r.Spec.InstanceType = stringValue("t3.large") },
+			nil),
+		Entry("validates the spec",
+			func(r *EC2Instance) {},
+			func(r *EC2Instance) { r.Spec.LaunchTimeout = &metav1.Duration{} },
+			[]string{"spec.launchTimeout"}),
+		Entry("rejects a region change",
+			func(r *EC2Instance) { r.Spec.Region = "eu-west-1" },
+			func(r *EC2Instance) { r.Spec.Region = "us-east-1" },
+			[]string{"spec.region"}),
+		Entry("rejects setting a region",
+			func(r *EC2Instance) {},
+			func(r *EC2Instance) { r.Spec.Region = "us-east-1" },
+			[]string{"spec.region"}),
+		Entry("rejects a providerConfigRef change",
+			func(r *EC2Instance) { r.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "a"} },
+			func(r *EC2Instance) { r.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "b"} },
+			[]string{"spec.providerConfigRef"}),
+		Entry("rejects removing the providerConfigRef",
+			func(r *EC2Instance) { r.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "a"} },
+			func(r *EC2Instance) { r.Spec.ProviderConfigRef = nil },
+			[]string{"spec.providerConfigRef"}),
+		Entry("reports only immutable field errors when both occur",
+			func(r *EC2Instance) {},
+			func(r *EC2Instance) {
+				r.Spec.Region = "us-east-1"
+				r.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "a"}
+				r.Spec.LaunchTimeout = &metav1.Duration{}
+				r.Spec.SubnetID = stringValue("subnet-1")
+				r.Spec.SubnetIDs = []option.String{stringValue("subnet-2")}
+			},
+			[]string{"spec.region", "spec.providerConfigRef"}),
+	)
+
+	DescribeTable("warnings",
+		func(mutate func(*EC2Instance), expectedWarnings []string) {
+			r := newValidEC2Instance()
+			mutate(r)
+			warnings, err := r.ValidateCreate()
+			Expect(err).Should(BeNil())
+			Expect(warnings).Should(HaveLen(len(expectedWarnings)))
+			for i, expected := range expectedWarnings {
+				Expect(warnings[i]).Should(HavePrefix(expected))
+			}
+
+			// Warnings are also returned when an update is rejected
+			old := r.DeepCopy()
+			old.Spec.Region = "eu-west-1"
+			warnings, err = r.ValidateUpdate(old)
+			Expect(err).ShouldNot(BeNil())
+			Expect(warnings).Should(HaveLen(len(expectedWarnings)))
+		},
+		Entry("none for a valid spec", func(r *EC2Instance) {}, nil),
+		Entry("none for a key name", func(r *EC2Instance) {
+			r.Spec.KeyName = stringValue("key")
+		}, nil),
+		Entry("none for a key name from a reference", func(r *EC2Instance) {
+			r.Spec.KeyName = option.String{ValueFrom: configMapRef()}
+		}, nil),
+		Entry("an empty key name", func(r *EC2Instance) {
+			r.Spec.KeyName = stringValue("")
+		}, []string{"spec.keyName:"}),
+		Entry("IMDSv1 by default", func(r *EC2Instance) {
+			r.Spec.MetadataOptions = nil
+		}, []string{"spec.metadataOptions:"}),
+		Entry("IMDSv1 with optional tokens", func(r *EC2Instance) {
+			r.Spec.MetadataOptions = &MetadataOptions{HTTPTokens: "optional"}
+		}, []string{"spec.metadataOptions:"}),
+		Entry("none with the metadata endpoint disabled", func(r *EC2Instance) {
+			r.Spec.MetadataOptions = &MetadataOptions{HTTPEndpoint: "disabled"}
+		}, nil),
+		Entry("none when metadata options are left to a launch template", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{Name: "template"}
+			r.Spec.MetadataOptions = nil
+		}, nil),
+		Entry("IMDSv1 with optional tokens and a launch template", func(r *EC2Instance) {
+			r.Spec.LaunchTemplate = &LaunchTemplateReference{Name: "template"}
+			r.Spec.MetadataOptions = &MetadataOptions{HTTPTokens: "optional"}
+		}, []string{"spec.metadataOptions:"}),
+		Entry("a maxCount value overriding valueFrom", func(r *EC2Instance) {
+			r.Spec.MaxCount.ValueFrom = configMapRef()
+		}, []string{"spec.maxCount: value overrides valueFrom"}),
+		Entry("a maxCount with only valueFrom", func(r *EC2Instance) {
+			r.Spec.MaxCount = option.Int{ValueFrom: configMapRef()}
+		}, []string{"spec.maxCount: the scale subresource reports no replica count"}),
+		Entry("each applicable warning in order", func(r *EC2Instance) {
+			r.Spec.KeyName = stringValue("")
+			r.Spec.MetadataOptions = nil
+			r.Spec.MaxCount = option.Int{ValueFrom: configMapRef()}
+		}, []string{"spec.keyName:", "spec.metadataOptions:", "spec.maxCount:"}),
+	)
+})
@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProviderConfigSpec defines the AWS credentials used by resources that
+// reference the ProviderConfig. The manager's own credentials are used for
+// anything not set here.
+type ProviderConfigSpec struct {
+	// CredentialsSecretRef names a Secret in the same namespace holding
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally
+	// AWS_SESSION_TOKEN
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+
+	// AssumeRole is a role assumed using the Secret's credentials, or the
+	// manager's credentials if no Secret is referenced
+	// +optional
+	AssumeRole *AssumeRole `json:"assumeRole,omitempty"`
+}
+
+// AssumeRole defines an IAM role to assume
+type AssumeRole struct {
+	// +kubebuilder:validation:Required
+	RoleARN string `json:"roleARN"`
+
+	// +optional
+	ExternalID string `json:"externalID,omitempty"`
+
+	// +optional
+	SessionName string `json:"sessionName,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProviderConfig is the Schema for the providerconfigs API
+type ProviderConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ProviderConfigSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProviderConfigList contains a list of ProviderConfig
+type ProviderConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProviderConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ProviderConfig{}, &ProviderConfigList{})
+}
@@ -20,6 +20,10 @@ import (
 	"context"
 	"flag"
 	"os"
+	"time"
+
+	// Embed the time zone database for schedules, as the base image has none
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -33,6 +37,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
 
 	awsv1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
@@ -57,11 +62,36 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var awsRegion string
+	var awsEndpointURL string
+	var awsRetryMode string
+	var awsRetryMaxAttempts int
+	var resyncPeriod time.Duration
+	var launchTimeout time.Duration
+	var publishRawStateData bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&awsRegion, "aws-region", defaultAWSRegion(),
+		"The default AWS region for EC2Instances that do not specify one. "+
+			"Defaults to the AWS_REGION environment variable, or us-east-1 if unset.")
+	flag.StringVar(&awsEndpointURL, "aws-endpoint-url", os.Getenv("AWS_ENDPOINT_URL"),
+		"A custom EC2 endpoint URL, e.g. for LocalStack. Defaults to the AWS_ENDPOINT_URL environment variable.")
+	flag.StringVar(&awsRetryMode, "aws-retry-mode", "",
+		"The AWS SDK retry mode, either standard or adaptive. The SDK default is used if unset.")
+	flag.IntVar(&awsRetryMaxAttempts, "aws-retry-max-attempts", 0,
+		"The maximum number of attempts per AWS request. The SDK default is used if unset.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often ready EC2Instances are re-checked against their spec, with jitter. "+
+			"Can be overridden per resource with spec.resyncPeriod. 0 disables periodic resyncs.")
+	flag.DurationVar(&launchTimeout, "launch-timeout", 5*time.Minute,
+		"How long launched instances may take to reach the running state before they are marked as failed. "+
+			"Can be overridden per resource with spec.launchTimeout.")
+	flag.BoolVar(&publishRawStateData, "publish-raw-state-data", false,
+		"Also publish the EC2 API's description of the instances and the spec under \"raw\" in StateDeclarations. "+
+			"These fields are not covered by the schema version and may change between releases.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -93,17 +123,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	ec2InstanceClient, err := ec2instanceclient.New(context.Background(), "us-east-1")
-	if err != nil {
-		setupLog.Error(err, "unable to create client", "client", "EC2InstanceClient")
+	var baseClientOpts []ec2instanceclient.Option
+	if awsEndpointURL != "" {
+		baseClientOpts = append(baseClientOpts, ec2instanceclient.WithEndpointURL(awsEndpointURL))
+	}
+	if awsRetryMode != "" {
+		retryMode, err := aws.ParseRetryMode(awsRetryMode)
+		if err != nil {
+			setupLog.Error(err, "invalid AWS retry mode")
+			os.Exit(1)
+		}
+		baseClientOpts = append(baseClientOpts, ec2instanceclient.WithRetryMode(retryMode))
+	}
+	if awsRetryMaxAttempts > 0 {
+		baseClientOpts = append(baseClientOpts, ec2instanceclient.WithRetryMaxAttempts(awsRetryMaxAttempts))
+	}
+
+	ec2InstanceClients := controller.NewEC2InstanceClientPool(
+		awsRegion,
+		func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (controller.EC2InstanceClient, error) {
+			// Options from a ProviderConfig take precedence over the manager's
+			clientOpts := append(append([]ec2instanceclient.Option{}, baseClientOpts...), opts...)
+			return ec2instanceclient.New(ctx, region, clientOpts...)
+		},
+	)
+	// Create the default region's client up front to catch configuration errors early
+	if _, err := ec2InstanceClients.Get(context.Background(), controller.ClientConfig{Region: awsRegion}); err != nil {
+		setupLog.Error(err, "unable to create client", "client", "EC2InstanceClient", "region", awsRegion)
 		os.Exit(1)
 	}
 
 	if err = (&controller.EC2InstanceReconciler{
-		Client:            mgr.GetClient(),
-		Scheme:            mgr.GetScheme(),
-		Recorder:          mgr.GetEventRecorderFor("ec2instance-controller"),
-		EC2InstanceClient: ec2InstanceClient,
+		Client:              mgr.GetClient(),
+		Scheme:              mgr.GetScheme(),
+		Recorder:            mgr.GetEventRecorderFor("ec2instance-controller"),
+		APIReader:           mgr.GetAPIReader(),
+		EC2InstanceClients:  ec2InstanceClients,
+		ResyncPeriod:        resyncPeriod,
+		LaunchTimeout:       launchTimeout,
+		PublishRawStateData: publishRawStateData,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "EC2Instance")
 		os.Exit(1)
@@ -131,3 +189,10 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func defaultAWSRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
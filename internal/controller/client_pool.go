@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+)
+
+// NewEC2InstanceClientFunc creates an EC2InstanceClient for a region
+type NewEC2InstanceClientFunc func(
+	ctx context.Context, region string, opts ...ec2instanceclient.Option,
+) (EC2InstanceClient, error)
+
+// ClientConfig describes the client required by an EC2Instance
+type ClientConfig struct {
+	Region string
+
+	// ProviderConfig is the namespaced name of the ProviderConfig supplying
+	// credentials, or empty if the manager's credentials are used
+	ProviderConfig string
+
+	// Version changes whenever the ProviderConfig or its credentials change,
+	// causing any cached client to be replaced
+	Version string
+
+	Options []ec2instanceclient.Option
+}
+
+type clientPoolKey struct {
+	region         string
+	providerConfig string
+}
+
+type versionedClient struct {
+	version string
+	client  EC2InstanceClient
+}
+
+// EC2InstanceClientPool lazily creates and caches one EC2InstanceClient per
+// region and ProviderConfig so that a single controller can manage instances
+// in many regions and AWS accounts.
+type EC2InstanceClientPool struct {
+	defaultRegion string
+	newClient     NewEC2InstanceClientFunc
+
+	mu      sync.Mutex
+	clients map[clientPoolKey]versionedClient
+
+	// lastUsed holds the client last used for each EC2Instance so that its
+	// instances can be terminated if its ProviderConfig is deleted first
+	lastUsed map[types.NamespacedName]EC2InstanceClient
+}
+
+func NewEC2InstanceClientPool(defaultRegion string, newClient NewEC2InstanceClientFunc) *EC2InstanceClientPool {
+	return &EC2InstanceClientPool{
+		defaultRegion: defaultRegion,
+		newClient:     newClient,
+		clients:       make(map[clientPoolKey]versionedClient),
+		lastUsed:      make(map[types.NamespacedName]EC2InstanceClient),
+	}
+}
+
+// Get returns the client for the given config, creating it if necessary.
+// The pool's default region is used if no region is set.
+func (p *EC2InstanceClientPool) Get(ctx context.Context, cfg ClientConfig) (EC2InstanceClient, error) {
+	region := p.Region(cfg)
+	key := clientPoolKey{region: region, providerConfig: cfg.ProviderConfig}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, exists := p.clients[key]; exists && c.version == cfg.Version {
+		return c.client, nil
+	}
+	c, err := p.newClient(ctx, region, cfg.Options...)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[key] = versionedClient{version: cfg.Version, client: c}
+	return c, nil
+}
+
+// Region returns the region used for the given config
+func (p *EC2InstanceClientPool) Region(cfg ClientConfig) string {
+	if cfg.Region == "" {
+		return p.defaultRegion
+	}
+	return cfg.Region
+}
+
+// SetLastUsed records the client last used for an EC2Instance
+func (p *EC2InstanceClientPool) SetLastUsed(name types.NamespacedName, c EC2InstanceClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastUsed[name] = c
+}
+
+// LastUsed returns the client last used for an EC2Instance, if any
+func (p *EC2InstanceClientPool) LastUsed(name types.NamespacedName) (EC2InstanceClient, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, exists := p.lastUsed[name]
+	return c, exists
+}
+
+// ForgetLastUsed removes the client recorded for a deleted EC2Instance
+func (p *EC2InstanceClientPool) ForgetLastUsed(name types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.lastUsed, name)
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	mockec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/mock_ec2instance_client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EC2InstanceClientPool", func() {
+	var created []string
+	var pool *EC2InstanceClientPool
+
+	BeforeEach(func() {
+		created = nil
+		pool = NewEC2InstanceClientPool("us-east-1", func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+			created = append(created, region)
+			return mockec2instanceclient.MockEC2InstanceClient{}, nil
+		})
+	})
+
+	It("should use the default region when none is given", func() {
+		_, err := pool.Get(context.Background(), ClientConfig{})
+		Expect(err).Should(BeNil())
+		Expect(created).Should(Equal([]string{"us-east-1"}))
+	})
+
+	It("should create one client per region and reuse it", func() {
+		for _, region := range []string{"eu-west-1", "us-west-2", "eu-west-1", "us-west-2"} {
+			_, err := pool.Get(context.Background(), ClientConfig{Region: region})
+			Expect(err).Should(BeNil())
+		}
+		Expect(created).Should(Equal([]string{"eu-west-1", "us-west-2"}))
+	})
+
+	It("should replace a client when the ProviderConfig version changes", func() {
+		cfg := ClientConfig{Region: "eu-west-1", ProviderConfig: "default/team-a", Version: "1"}
+		for _, version := range []string{"1", "1", "2"} {
+			cfg.Version = version
+			_, err := pool.Get(context.Background(), cfg)
+			Expect(err).Should(BeNil())
+		}
+		_, err := pool.Get(context.Background(), ClientConfig{Region: "eu-west-1"})
+		Expect(err).Should(BeNil())
+		Expect(created).Should(Equal([]string{"eu-west-1", "eu-west-1", "eu-west-1"}))
+	})
+})
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// setStatusCondition sets a condition, recording the generation of the spec
+// last applied. A new generation is only recorded once its references have
+// been resolved and the controller starts applying it.
+func setStatusCondition(ec2Instance *ec2instancev1alpha1.EC2Instance, condition metav1.Condition) {
+	condition.ObservedGeneration = ec2Instance.Status.ObservedGeneration
+	meta.SetStatusCondition(&ec2Instance.Status.Conditions, condition)
+}
+
+// setFailedCondition sets a condition describing a failed reconcile, and sets
+// Synced to false with the same reason
+func setFailedCondition(ec2Instance *ec2instancev1alpha1.EC2Instance, condition metav1.Condition) {
+	setStatusCondition(ec2Instance, condition)
+	setStatusCondition(ec2Instance, metav1.Condition{
+		Type:    conditionTypeSynced,
+		Status:  metav1.ConditionFalse,
+		Reason:  condition.Reason,
+		Message: condition.Message,
+	})
+}
+
+// instancesHealthyCondition reports whether all instances are passing status
+// checks. Status checks only run on running instances.
+func instancesHealthyCondition(desiredState string, instanceCount, availableCount int) metav1.Condition {
+	condition := metav1.Condition{Type: conditionTypeInstancesHealthy}
+	switch {
+	case desiredState != ec2instancev1alpha1.DesiredStateRunning:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NotRunning"
+		condition.Message = fmt.Sprintf("Status checks do not run on instances in the %s state", desiredState)
+	case availableCount < instanceCount:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "StatusChecksNotPassing"
+		condition.Message = fmt.Sprintf("%d of %d instance(s) are passing status checks", availableCount, instanceCount)
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "StatusChecksPassing"
+		condition.Message = fmt.Sprintf("%d of %d instance(s) are passing status checks", availableCount, instanceCount)
+	}
+	return condition
+}
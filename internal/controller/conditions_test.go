@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("status conditions", func() {
+	var ec2Instance *v1alpha1.EC2Instance
+
+	BeforeEach(func() {
+		ec2Instance = &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Generation: 3},
+			Status:     v1alpha1.EC2InstanceStatus{ObservedGeneration: 2},
+		}
+	})
+
+	It("should record the applied generation on each condition", func() {
+		setStatusCondition(ec2Instance, metav1.Condition{
+			Type:   conditionTypeStateDeclared,
+			Status: metav1.ConditionTrue,
+			Reason: "Declared",
+		})
+
+		condition := meta.FindStatusCondition(ec2Instance.Status.Conditions, conditionTypeStateDeclared)
+		Expect(condition).ShouldNot(BeNil())
+		Expect(condition.ObservedGeneration).Should(Equal(int64(2)))
+	})
+
+	It("should mark the resource as not synced when a reconcile fails", func() {
+		setFailedCondition(ec2Instance, metav1.Condition{
+			Type:    conditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RunFailed",
+			Message: "Failed to scale up EC2 instances",
+		})
+
+		synced := meta.FindStatusCondition(ec2Instance.Status.Conditions, conditionTypeSynced)
+		Expect(synced).ShouldNot(BeNil())
+		Expect(synced.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(synced.Reason).Should(Equal("RunFailed"))
+		Expect(meta.IsStatusConditionFalse(ec2Instance.Status.Conditions, conditionTypeReady)).Should(BeTrue())
+	})
+})
+
+var _ = Describe("instancesHealthyCondition", func() {
+	It("should report instances failing status checks", func() {
+		condition := instancesHealthyCondition(v1alpha1.DesiredStateRunning, 3, 2)
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Message).Should(Equal("2 of 3 instance(s) are passing status checks"))
+	})
+
+	It("should report healthy when all instances pass status checks", func() {
+		condition := instancesHealthyCondition(v1alpha1.DesiredStateRunning, 3, 3)
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should not require status checks for stopped instances", func() {
+		condition := instancesHealthyCondition(v1alpha1.DesiredStateStopped, 3, 0)
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).Should(Equal("NotRunning"))
+	})
+})
@@ -20,14 +20,28 @@ import (
 	"fmt"
 
 	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	"github.com/kraken-iac/common/types/option"
 	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
 )
 
 type ec2InstanceApplicableValues struct {
-	imageID      string
-	instanceType string
-	maxCount     int
-	minCount     int
+	imageID            string
+	instanceType       string
+	inPlaceTypeChange  bool
+	maxCount           int
+	minCount           int
+	launchTemplate     *ec2instanceclient.LaunchTemplateSpecification
+	subnetIDs          []string
+	securityGroupIDs   []string
+	userData           *string
+	keyName            string
+	iamInstanceProfile string
+	spotOptions        *ec2instanceclient.SpotOptions
+	metadataOptions    *ec2instanceclient.MetadataOptions
+	rootVolume         *ec2instanceclient.EBSVolume
+	dataVolumes        []ec2instanceclient.BlockDeviceMapping
+	hibernation        bool
 }
 
 func toApplicableValues(
@@ -36,22 +50,35 @@ func toApplicableValues(
 ) (*ec2InstanceApplicableValues, error) {
 	av := ec2InstanceApplicableValues{}
 
+	// ImageID and InstanceType are only required if no launch template is
+	// referenced; otherwise they override the template's values when set
+	if lt := ec2Spec.LaunchTemplate; lt != nil {
+		av.launchTemplate = &ec2instanceclient.LaunchTemplateSpecification{
+			ID:      lt.ID,
+			Name:    lt.Name,
+			Version: lt.Version,
+		}
+	}
+
 	if imageID, err := ec2Spec.ImageID.ToApplicableValue(depValues); err != nil {
 		return nil, err
-	} else if imageID == nil {
-		return nil, fmt.Errorf("no applicable value provided for ImageID")
-	} else {
+	} else if imageID != nil {
 		av.imageID = *imageID
+	} else if av.launchTemplate == nil {
+		return nil, fmt.Errorf("no applicable value provided for ImageID")
 	}
 
 	if instanceType, err := ec2Spec.InstanceType.ToApplicableValue(depValues); err != nil {
 		return nil, err
-	} else if instanceType == nil {
-		return nil, fmt.Errorf("no applicable value provided for InstanceType")
-	} else {
+	} else if instanceType != nil {
 		av.instanceType = *instanceType
+	} else if av.launchTemplate == nil {
+		return nil, fmt.Errorf("no applicable value provided for InstanceType")
 	}
 
+	// Existing instances have their type changed rather than being replaced
+	av.inPlaceTypeChange = ec2Spec.InstanceTypeChangePolicy == v1alpha1.InPlaceInstanceTypeChangePolicy
+
 	if maxCount, err := ec2Spec.MaxCount.ToApplicableValue(depValues); err != nil {
 		return nil, err
 	} else if maxCount == nil {
@@ -67,6 +94,113 @@ func toApplicableValues(
 	} else {
 		av.minCount = *minCount
 	}
+	// Scaling below minCount lowers it, as scale writes only set maxCount
+	if av.minCount > av.maxCount {
+		av.minCount = av.maxCount
+	}
+
+	if subnetID, err := ec2Spec.SubnetID.ToApplicableValue(depValues); err != nil {
+		return nil, err
+	} else if subnetID != nil {
+		av.subnetIDs = []string{*subnetID}
+	}
+
+	if subnetIDs, err := toApplicableStringList(ec2Spec.SubnetIDs, depValues); err != nil {
+		return nil, fmt.Errorf("SubnetIDs: %w", err)
+	} else if len(subnetIDs) > 0 {
+		av.subnetIDs = subnetIDs
+	}
+
+	if securityGroupIDs, err := toApplicableStringList(ec2Spec.SecurityGroupIDs, depValues); err != nil {
+		return nil, fmt.Errorf("SecurityGroupIDs: %w", err)
+	} else {
+		av.securityGroupIDs = securityGroupIDs
+	}
+
+	// User data referencing a Secret is resolved separately by the reconciler
+	if ec2Spec.UserData.ValueFrom == nil || ec2Spec.UserData.ValueFrom.Secret == nil {
+		if userData, err := ec2Spec.UserData.ToApplicableValue(depValues); err != nil {
+			return nil, err
+		} else {
+			av.userData = userData
+		}
+	}
+
+	if keyName, err := ec2Spec.KeyName.ToApplicableValue(depValues); err != nil {
+		return nil, err
+	} else if keyName != nil {
+		av.keyName = *keyName
+	}
+
+	if iamInstanceProfile, err := ec2Spec.IAMInstanceProfile.ToApplicableValue(depValues); err != nil {
+		return nil, err
+	} else if iamInstanceProfile != nil {
+		av.iamInstanceProfile = *iamInstanceProfile
+	}
+
+	if mo := ec2Spec.InstanceMarketOptions; mo != nil && mo.Spot != nil {
+		av.spotOptions = &ec2instanceclient.SpotOptions{
+			MaxPrice:             mo.Spot.MaxPrice,
+			InterruptionBehavior: mo.Spot.InterruptionBehavior,
+			RequestType:          mo.Spot.RequestType,
+		}
+	}
+
+	if mo := ec2Spec.MetadataOptions; mo != nil {
+		av.metadataOptions = &ec2instanceclient.MetadataOptions{
+			HTTPTokens:              mo.HTTPTokens,
+			HTTPPutResponseHopLimit: mo.HTTPPutResponseHopLimit,
+			HTTPEndpoint:            mo.HTTPEndpoint,
+			InstanceMetadataTags:    mo.InstanceMetadataTags,
+		}
+	}
+
+	if bdm := ec2Spec.BlockDeviceMappings; bdm != nil {
+		if bdm.RootVolume != nil {
+			rootVolume := toEBSVolume(*bdm.RootVolume)
+			av.rootVolume = &rootVolume
+		}
+		for _, dataVolume := range bdm.DataVolumes {
+			av.dataVolumes = append(av.dataVolumes, ec2instanceclient.BlockDeviceMapping{
+				DeviceName: dataVolume.DeviceName,
+				EBSVolume:  toEBSVolume(dataVolume.EBSVolume),
+			})
+		}
+	}
+
+	av.hibernation = ec2Spec.Hibernation
 
 	return &av, nil
 }
+
+func toEBSVolume(volume v1alpha1.EBSVolume) ec2instanceclient.EBSVolume {
+	return ec2instanceclient.EBSVolume{
+		SizeGiB:             volume.SizeGiB,
+		VolumeType:          volume.VolumeType,
+		IOPS:                volume.IOPS,
+		Throughput:          volume.Throughput,
+		Encrypted:           volume.Encrypted,
+		KMSKeyID:            volume.KMSKeyID,
+		DeleteOnTermination: volume.DeleteOnTermination,
+	}
+}
+
+func toApplicableStringList(
+	opts []option.String,
+	depValues krakenv1alpha1.DependentValues,
+) ([]string, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	values := make([]string, len(opts))
+	for i, opt := range opts {
+		value, err := opt.ToApplicableValue(depValues)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		} else if value == nil {
+			return nil, fmt.Errorf("no applicable value provided for index %d", i)
+		}
+		values[i] = *value
+	}
+	return values, nil
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	"github.com/kraken-iac/common/types/option"
+	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("toApplicableValues", func() {
+	var (
+		imageID      = "ami-1234abcd"
+		instanceType = "t2.nano"
+		count        = 1
+		sgInline     = "sg-inline"
+	)
+
+	var spec v1alpha1.EC2InstanceSpec
+
+	BeforeEach(func() {
+		spec = v1alpha1.EC2InstanceSpec{
+			ImageID:      option.String{Value: &imageID},
+			InstanceType: option.String{Value: &instanceType},
+			MaxCount:     option.Int{Value: &count},
+			MinCount:     option.Int{Value: &count},
+		}
+	})
+
+	It("should resolve a list of inline and referenced security group IDs", func() {
+		spec.SecurityGroupIDs = []option.String{
+			{Value: &sgInline},
+			{ValueFrom: &option.ValueFrom{
+				ConfigMap: &option.ValueFromConfigMap{Name: "network", Key: "securityGroupID"},
+			}},
+		}
+		depValues := krakenv1alpha1.DependentValues{
+			FromConfigMaps: krakenv1alpha1.DependentValuesFromConfigMaps{
+				"network": {"securityGroupID": "sg-from-configmap"},
+			},
+		}
+
+		av, err := toApplicableValues(spec, depValues)
+		Expect(err).Should(BeNil())
+		Expect(av.securityGroupIDs).Should(Equal([]string{sgInline, "sg-from-configmap"}))
+	})
+
+	It("should fail when a referenced security group ID cannot be resolved", func() {
+		spec.SecurityGroupIDs = []option.String{
+			{ValueFrom: &option.ValueFrom{
+				ConfigMap: &option.ValueFromConfigMap{Name: "network", Key: "securityGroupID"},
+			}},
+		}
+
+		_, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should convert block device mappings", func() {
+		rootSize, iops := int32(100), int32(4000)
+		encrypted := true
+		spec.BlockDeviceMappings = &v1alpha1.BlockDeviceMappings{
+			RootVolume: &v1alpha1.EBSVolume{SizeGiB: &rootSize, VolumeType: "gp3"},
+			DataVolumes: []v1alpha1.DataVolume{{
+				DeviceName: "/dev/sdf",
+				EBSVolume:  v1alpha1.EBSVolume{VolumeType: "io2", IOPS: &iops, Encrypted: &encrypted, KMSKeyID: "alias/ebs"},
+			}},
+		}
+
+		av, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).Should(BeNil())
+		Expect(av.rootVolume).Should(Equal(&ec2instanceclient.EBSVolume{SizeGiB: &rootSize, VolumeType: "gp3"}))
+		Expect(av.dataVolumes).Should(Equal([]ec2instanceclient.BlockDeviceMapping{{
+			DeviceName: "/dev/sdf",
+			EBSVolume:  ec2instanceclient.EBSVolume{VolumeType: "io2", IOPS: &iops, Encrypted: &encrypted, KMSKeyID: "alias/ebs"},
+		}}))
+	})
+
+	It("should allow ImageID and InstanceType to be omitted with a launch template", func() {
+		spec.ImageID = option.String{}
+		spec.InstanceType = option.String{}
+		spec.LaunchTemplate = &v1alpha1.LaunchTemplateReference{Name: "base", Version: "$Latest"}
+
+		av, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).Should(BeNil())
+		Expect(av.imageID).Should(BeEmpty())
+		Expect(av.launchTemplate).Should(Equal(&ec2instanceclient.LaunchTemplateSpecification{Name: "base", Version: "$Latest"}))
+	})
+
+	It("should require ImageID without a launch template", func() {
+		spec.ImageID = option.String{}
+
+		_, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("should prefer a scaled maxCount value over valueFrom", func() {
+		scaled := 5
+		spec.MaxCount = option.Int{
+			Value: &scaled,
+			ValueFrom: &option.ValueFrom{
+				ConfigMap: &option.ValueFromConfigMap{Name: "capacity", Key: "maxCount"},
+			},
+		}
+
+		av, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).Should(BeNil())
+		Expect(av.maxCount).Should(Equal(5))
+		dependencyRequestSpec, err := spec.GenerateDependencyRequestSpec()
+		Expect(err).Should(BeNil())
+		Expect(dependencyRequestSpec.HasDependencies()).Should(BeFalse())
+	})
+
+	It("should lower minCount when scaled below it", func() {
+		scaled, min := 1, 3
+		spec.MaxCount = option.Int{Value: &scaled}
+		spec.MinCount = option.Int{Value: &min}
+
+		av, err := toApplicableValues(spec, krakenv1alpha1.DependentValues{})
+		Expect(err).Should(BeNil())
+		Expect(av.minCount).Should(Equal(1))
+	})
+})
+
+var _ = Describe("GenerateDependencyRequestSpec", func() {
+	secretRef := &option.ValueFrom{Secret: &option.ValueFromSecret{Name: "bootstrap", Key: "value"}}
+
+	It("should leave user data referencing a Secret to the controller", func() {
+		spec := v1alpha1.EC2InstanceSpec{UserData: option.String{ValueFrom: secretRef}}
+
+		dependencyRequestSpec, err := spec.GenerateDependencyRequestSpec()
+		Expect(err).Should(BeNil())
+		Expect(dependencyRequestSpec.HasDependencies()).Should(BeFalse())
+	})
+
+	It("should reject Secret references on other fields instead of panicking", func() {
+		spec := v1alpha1.EC2InstanceSpec{
+			ImageID:  option.String{ValueFrom: secretRef},
+			SubnetID: option.String{ValueFrom: secretRef},
+		}
+
+		_, err := spec.GenerateDependencyRequestSpec()
+		Expect(err).Should(MatchError(ContainSubstring("spec.imageID.valueFrom.secret")))
+		Expect(err).Should(MatchError(ContainSubstring("spec.subnetID.valueFrom.secret")))
+	})
+})
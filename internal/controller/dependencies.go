@@ -0,0 +1,182 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kraken-iac/common/types/option"
+	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+const (
+	// redactedValue replaces values read from Secrets in the status
+	redactedValue = "<redacted>"
+
+	// maxReferencedValueLength is the length at which resolved values are
+	// truncated in the status
+	maxReferencedValueLength = 256
+)
+
+// valueReference is a spec field whose value is referenced
+type valueReference struct {
+	field     *field.Path
+	valueFrom *option.ValueFrom
+
+	// hashed fields, such as user data, are recorded as the SHA-256 hash
+	// of their value as they are large and often contain credentials
+	hashed bool
+
+	// resolve returns the field's value from the DependencyRequest's values
+	resolve func(dv krakenv1alpha1.DependentValues) (string, error)
+}
+
+func stringReference(path *field.Path, s option.String) valueReference {
+	return valueReference{
+		field:     path,
+		valueFrom: s.ValueFrom,
+		resolve: func(dv krakenv1alpha1.DependentValues) (string, error) {
+			value, err := s.ToApplicableValue(dv)
+			if err != nil || value == nil {
+				return "", err
+			}
+			return *value, nil
+		},
+	}
+}
+
+func intReference(path *field.Path, i option.Int) valueReference {
+	return valueReference{
+		field:     path,
+		valueFrom: i.ValueFrom,
+		resolve: func(dv krakenv1alpha1.DependentValues) (string, error) {
+			value, err := i.ToApplicableValue(dv)
+			if err != nil || value == nil {
+				return "", err
+			}
+			return strconv.Itoa(*value), nil
+		},
+	}
+}
+
+// specValueReferences returns the spec fields whose values are referenced
+func specValueReferences(spec ec2instancev1alpha1.EC2InstanceSpec) []valueReference {
+	specPath := field.NewPath("spec")
+	var refs []valueReference
+	addString := func(path *field.Path, s option.String) {
+		if s.ValueFrom != nil {
+			refs = append(refs, stringReference(path, s))
+		}
+	}
+	addString(specPath.Child("imageID"), spec.ImageID)
+	addString(specPath.Child("instanceType"), spec.InstanceType)
+	// A value set through the scale subresource overrides valueFrom
+	if spec.MaxCount.ValueFrom != nil && spec.MaxCount.Value == nil {
+		refs = append(refs, intReference(specPath.Child("maxCount"), spec.MaxCount))
+	}
+	if spec.MinCount.ValueFrom != nil {
+		refs = append(refs, intReference(specPath.Child("minCount"), spec.MinCount))
+	}
+	addString(specPath.Child("subnetID"), spec.SubnetID)
+	for i, subnetID := range spec.SubnetIDs {
+		addString(specPath.Child("subnetIDs").Index(i), subnetID)
+	}
+	for i, securityGroupID := range spec.SecurityGroupIDs {
+		addString(specPath.Child("securityGroupIDs").Index(i), securityGroupID)
+	}
+	addString(specPath.Child("keyName"), spec.KeyName)
+	addString(specPath.Child("iamInstanceProfile"), spec.IAMInstanceProfile)
+	if spec.UserData.ValueFrom != nil {
+		userData := stringReference(specPath.Child("userData"), spec.UserData)
+		userData.hashed = true
+		refs = append(refs, userData)
+	}
+	return refs
+}
+
+// describeValueFrom describes a reference for the status
+func describeValueFrom(valueFrom option.ValueFrom) string {
+	switch {
+	case valueFrom.ConfigMap != nil:
+		return fmt.Sprintf("configMap %s/%s", valueFrom.ConfigMap.Name, valueFrom.ConfigMap.Key)
+	case valueFrom.Secret != nil:
+		return fmt.Sprintf("secret %s/%s", valueFrom.Secret.Name, valueFrom.Secret.Key)
+	case valueFrom.KrakenResource != nil:
+		return fmt.Sprintf("krakenResource %s/%s %s",
+			valueFrom.KrakenResource.Kind, valueFrom.KrakenResource.Name, valueFrom.KrakenResource.Path)
+	}
+	return ""
+}
+
+// dependencyStatus describes the spec's references and the values they
+// resolve to. References to Secrets are read by the controller rather than
+// the DependencyRequest, so secretsResolved reports whether they were read,
+// and the DependencyRequest is only named if other references exist. Values
+// from Secrets are redacted, user data is hashed and long values are
+// truncated. Nil is returned if the spec references no values.
+func dependencyStatus(
+	spec ec2instancev1alpha1.EC2InstanceSpec,
+	dependencyRequestName string,
+	dv krakenv1alpha1.DependentValues,
+	secretsResolved bool,
+) *ec2instancev1alpha1.DependencyStatus {
+	refs := specValueReferences(spec)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	status := &ec2instancev1alpha1.DependencyStatus{}
+	for _, ref := range refs {
+		value := ec2instancev1alpha1.ReferencedValue{
+			Field:  ref.field.String(),
+			Source: describeValueFrom(*ref.valueFrom),
+		}
+		if ref.valueFrom.Secret != nil {
+			if !secretsResolved {
+				status.Unresolved = append(status.Unresolved, value)
+				continue
+			}
+			value.Value = redactedValue
+			status.Resolved = append(status.Resolved, value)
+			continue
+		}
+		status.DependencyRequestName = dependencyRequestName
+		resolved, err := ref.resolve(dv)
+		if err != nil {
+			status.Unresolved = append(status.Unresolved, value)
+			continue
+		}
+		value.Value = statusValue(ref, resolved)
+		status.Resolved = append(status.Resolved, value)
+	}
+	return status
+}
+
+// statusValue returns the resolved value recorded in the status
+func statusValue(ref valueReference, resolved string) string {
+	if ref.hashed {
+		return "sha256:" + hashUserData(&resolved)
+	}
+	if len(resolved) > maxReferencedValueLength {
+		return resolved[:maxReferencedValueLength] + "..."
+	}
+	return resolved
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	"github.com/kraken-iac/common/types/option"
+	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("dependencyStatus", func() {
+	var (
+		imageID      = "ami-1234abcd"
+		instanceType = "t3.micro"
+		count        = 1
+		spec         v1alpha1.EC2InstanceSpec
+	)
+
+	BeforeEach(func() {
+		spec = v1alpha1.EC2InstanceSpec{
+			ImageID: option.String{Value: &imageID},
+			InstanceType: option.String{ValueFrom: &option.ValueFrom{
+				ConfigMap: &option.ValueFromConfigMap{Name: "sizing", Key: "instanceType"},
+			}},
+			MaxCount: option.Int{Value: &count},
+			MinCount: option.Int{Value: &count},
+			SubnetIDs: []option.String{{ValueFrom: &option.ValueFrom{
+				KrakenResource: &option.ValueFromKrakenResource{Kind: "Subnet", Name: "private", Path: "subnetID"},
+			}}},
+			UserData: option.String{ValueFrom: &option.ValueFrom{
+				Secret: &option.ValueFromSecret{Name: "bootstrap", Key: "userData"},
+			}},
+		}
+	})
+
+	It("should return nil when no values are referenced", func() {
+		Expect(dependencyStatus(v1alpha1.EC2InstanceSpec{}, "ec2instance-web", krakenv1alpha1.DependentValues{}, false)).Should(BeNil())
+	})
+
+	It("should list resolved and unresolved references with secrets redacted", func() {
+		dv := krakenv1alpha1.DependentValues{
+			FromConfigMaps: krakenv1alpha1.DependentValuesFromConfigMaps{
+				"sizing": {"instanceType": "t3.micro"},
+			},
+		}
+
+		status := dependencyStatus(spec, "ec2instance-web", dv, true)
+		Expect(status).Should(Equal(&v1alpha1.DependencyStatus{
+			DependencyRequestName: "ec2instance-web",
+			Unresolved: []v1alpha1.ReferencedValue{
+				{Field: "spec.subnetIDs[0]", Source: "krakenResource Subnet/private subnetID"},
+			},
+			Resolved: []v1alpha1.ReferencedValue{
+				{Field: "spec.instanceType", Source: "configMap sizing/instanceType", Value: "t3.micro"},
+				{Field: "spec.userData", Source: "secret bootstrap/userData", Value: redactedValue},
+			},
+		}))
+	})
+
+	It("should not name a DependencyRequest when only Secrets are referenced", func() {
+		spec.InstanceType = option.String{Value: &instanceType}
+		spec.SubnetIDs = nil
+
+		status := dependencyStatus(spec, "ec2instance-web", krakenv1alpha1.DependentValues{}, false)
+		Expect(status.DependencyRequestName).Should(BeEmpty())
+		Expect(status.Unresolved).Should(HaveLen(1))
+		Expect(status.Resolved).Should(BeEmpty())
+	})
+
+	It("should record user data as a hash and truncate long values", func() {
+		userData := "#!/bin/bash\nexport TOKEN=secret\n"
+		longImageID := strings.Repeat("a", maxReferencedValueLength+10)
+		spec.ImageID = option.String{ValueFrom: &option.ValueFrom{
+			ConfigMap: &option.ValueFromConfigMap{Name: "images", Key: "imageID"},
+		}}
+		spec.UserData = option.String{ValueFrom: &option.ValueFrom{
+			ConfigMap: &option.ValueFromConfigMap{Name: "bootstrap", Key: "userData"},
+		}}
+		dv := krakenv1alpha1.DependentValues{
+			FromConfigMaps: krakenv1alpha1.DependentValuesFromConfigMaps{
+				"images":    {"imageID": longImageID},
+				"bootstrap": {"userData": userData},
+			},
+		}
+
+		status := dependencyStatus(spec, "ec2instance-web", dv, true)
+		Expect(status.Resolved).Should(ContainElements(
+			v1alpha1.ReferencedValue{
+				Field:  "spec.imageID",
+				Source: "configMap images/imageID",
+				Value:  longImageID[:maxReferencedValueLength] + "...",
+			},
+			v1alpha1.ReferencedValue{
+				Field:  "spec.userData",
+				Source: "configMap bootstrap/userData",
+				Value:  "sha256:" + hashUserData(&userData),
+			},
+		))
+	})
+})
+
+var _ = Describe("Reconcile dependencies", func() {
+	It("should record the DependencyRequest and the values it resolves", func() {
+		ctx := context.Background()
+		testScheme := newTestScheme()
+
+		imageID, count := "ami-1234abcd", 1
+		ec2Instance := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Generation: 2},
+			Spec: v1alpha1.EC2InstanceSpec{
+				ImageID: option.String{Value: &imageID},
+				InstanceType: option.String{ValueFrom: &option.ValueFrom{
+					ConfigMap: &option.ValueFromConfigMap{Name: "sizing", Key: "instanceType"},
+				}},
+				MaxCount: option.Int{Value: &count},
+				MinCount: option.Int{Value: &count},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(ec2Instance).
+			WithStatusSubresource(ec2Instance, &krakenv1alpha1.DependencyRequest{}).
+			Build()
+		ec2Client := &fakeEC2Client{}
+		r := &EC2InstanceReconciler{
+			Client:        fakeClient,
+			Scheme:        testScheme,
+			Recorder:      record.NewFakeRecorder(10),
+			APIReader:     fakeClient,
+			LaunchTimeout: time.Minute,
+			EC2InstanceClients: NewEC2InstanceClientPool(
+				"us-east-1",
+				func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+					return ec2Client, nil
+				},
+			),
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ec2Instance)}
+		reconcile := func() {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(fakeClient.Get(ctx, req.NamespacedName, ec2Instance)).To(Succeed())
+		}
+
+		By("creating the DependencyRequest")
+		reconcile()
+		reconcile()
+		reconcile()
+		Expect(ec2Instance.Status.Dependencies).Should(Equal(&v1alpha1.DependencyStatus{
+			DependencyRequestName: "ec2instance-web",
+			Unresolved: []v1alpha1.ReferencedValue{
+				{Field: "spec.instanceType", Source: "configMap sizing/instanceType"},
+			},
+		}))
+		Expect(meta.IsStatusConditionFalse(ec2Instance.Status.Conditions, conditionTypeDependenciesResolved)).Should(BeTrue())
+		Expect(meta.IsStatusConditionFalse(ec2Instance.Status.Conditions, conditionTypeSynced)).Should(BeTrue())
+		Expect(ec2Instance.Status.ObservedGeneration).Should(BeZero())
+
+		By("recording the resolved values once the DependencyRequest is ready")
+		dependencyRequest := &krakenv1alpha1.DependencyRequest{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "ec2instance-web", Namespace: "default"}, dependencyRequest)).To(Succeed())
+		meta.SetStatusCondition(&dependencyRequest.Status.Conditions, metav1.Condition{
+			Type:   krakenv1alpha1.ConditionTypeReady,
+			Status: metav1.ConditionTrue,
+			Reason: "Resolved",
+		})
+		dependencyRequest.Status.DependentValues = krakenv1alpha1.DependentValues{
+			FromConfigMaps: krakenv1alpha1.DependentValuesFromConfigMaps{
+				"sizing": {"instanceType": "t3.micro"},
+			},
+		}
+		Expect(fakeClient.Status().Update(ctx, dependencyRequest)).To(Succeed())
+
+		reconcile()
+		Expect(ec2Instance.Status.Dependencies.Unresolved).Should(BeEmpty())
+		Expect(ec2Instance.Status.Dependencies.Resolved).Should(Equal([]v1alpha1.ReferencedValue{
+			{Field: "spec.instanceType", Source: "configMap sizing/instanceType", Value: "t3.micro"},
+		}))
+		Expect(meta.IsStatusConditionTrue(ec2Instance.Status.Conditions, conditionTypeDependenciesResolved)).Should(BeTrue())
+		Expect(ec2Instance.Status.ObservedGeneration).Should(Equal(int64(2)))
+		Expect(meta.FindStatusCondition(ec2Instance.Status.Conditions, conditionTypeSynced).ObservedGeneration).
+			Should(Equal(int64(2)))
+	})
+})
@@ -0,0 +1,209 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// instanceDrift describes how an instance differs from the applicable values
+type instanceDrift struct {
+	instance types.Instance
+	reasons  []string
+
+	// replace is true if the drift cannot be corrected in place
+	replace bool
+
+	// changeInstanceType is true if the instance type is to be changed in
+	// place
+	changeInstanceType bool
+
+	// missingTags are the tags to apply to correct drift in place
+	missingTags map[string]string
+}
+
+func (d instanceDrift) action() string {
+	if d.replace {
+		return ec2instancev1alpha1.DriftActionReplaced
+	}
+	return ec2instancev1alpha1.DriftActionUpdated
+}
+
+// detectInstanceDrift compares each instance against the applicable values
+// and tags, returning the instances that have drifted. Fields left to EC2 or
+// a launch template to decide are not compared.
+//
+// The user data of an instance cannot be read back without stopping it, so
+// instances are tagged with the hash of their user data at launch. Instances
+// launched before this tag was introduced are assumed to have the user data
+// last recorded in the status, lastUserDataHash.
+func detectInstanceDrift(
+	instances []types.Instance,
+	av *ec2InstanceApplicableValues,
+	tags map[string]string,
+	userDataHash string,
+	lastUserDataHash string,
+) []instanceDrift {
+	var drifts []instanceDrift
+	for _, inst := range instances {
+		d := instanceDrift{instance: inst}
+
+		if av.imageID != "" && aws.ToString(inst.ImageId) != av.imageID {
+			d.reasons = append(d.reasons,
+				fmt.Sprintf("image ID is %q, expected %q", aws.ToString(inst.ImageId), av.imageID))
+			d.replace = true
+		}
+
+		if av.instanceType != "" && string(inst.InstanceType) != av.instanceType {
+			d.reasons = append(d.reasons,
+				fmt.Sprintf("instance type is %q, expected %q", inst.InstanceType, av.instanceType))
+			if av.inPlaceTypeChange && canChangeInstanceTypeInPlace(inst) {
+				d.changeInstanceType = true
+			} else {
+				d.replace = true
+			}
+		}
+
+		if len(av.subnetIDs) > 0 && !containsString(av.subnetIDs, aws.ToString(inst.SubnetId)) {
+			d.reasons = append(d.reasons,
+				fmt.Sprintf("subnet %q is not one of %v", aws.ToString(inst.SubnetId), av.subnetIDs))
+			d.replace = true
+		}
+
+		if len(av.securityGroupIDs) > 0 {
+			groupIDs := make([]string, 0, len(inst.SecurityGroups))
+			for _, group := range inst.SecurityGroups {
+				groupIDs = append(groupIDs, aws.ToString(group.GroupId))
+			}
+			if !equalStringSets(groupIDs, av.securityGroupIDs) {
+				d.reasons = append(d.reasons,
+					fmt.Sprintf("security groups are %v, expected %v", sortedStrings(groupIDs), sortedStrings(av.securityGroupIDs)))
+				d.replace = true
+			}
+		}
+
+		if av.hibernation && (inst.HibernationOptions == nil || !aws.ToBool(inst.HibernationOptions.Configured)) {
+			d.reasons = append(d.reasons, "hibernation is not enabled")
+			d.replace = true
+		}
+
+		instanceTags := make(map[string]string, len(inst.Tags))
+		for _, tag := range inst.Tags {
+			instanceTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+
+		instanceUserDataHash, tagged := instanceTags[userDataHashTagKey]
+		if !tagged && lastUserDataHash != "" {
+			instanceUserDataHash = lastUserDataHash
+		} else if !tagged {
+			instanceUserDataHash = userDataHash
+		}
+		if instanceUserDataHash != userDataHash {
+			d.reasons = append(d.reasons, "user data has changed")
+			d.replace = true
+		}
+		tagKeys := make([]string, 0, len(tags))
+		for key := range tags {
+			tagKeys = append(tagKeys, key)
+		}
+		sort.Strings(tagKeys)
+		for _, key := range tagKeys {
+			if key == userDataHashTagKey {
+				continue
+			}
+			value, exists := instanceTags[key]
+			if !exists {
+				d.reasons = append(d.reasons, fmt.Sprintf("tag %q is missing", key))
+			} else if value != tags[key] {
+				d.reasons = append(d.reasons,
+					fmt.Sprintf("tag %q is %q, expected %q", key, value, tags[key]))
+			} else {
+				continue
+			}
+			if d.missingTags == nil {
+				d.missingTags = make(map[string]string)
+			}
+			d.missingTags[key] = tags[key]
+		}
+
+		if d.replace {
+			d.changeInstanceType = false
+		}
+		if len(d.reasons) > 0 {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts
+}
+
+// canChangeInstanceTypeInPlace reports whether an instance can be stopped to
+// change its type. Spot instances cannot have their type changed and
+// instances with an instance store root volume cannot be stopped.
+func canChangeInstanceTypeInPlace(inst types.Instance) bool {
+	return inst.InstanceLifecycle != types.InstanceLifecycleTypeSpot &&
+		inst.RootDeviceType != types.DeviceTypeInstanceStore
+}
+
+// removeInstances returns the instances that are not in the removed list
+func removeInstances(instances []types.Instance, removed []types.Instance) []types.Instance {
+	removedIDs := make(map[string]bool, len(removed))
+	for _, inst := range removed {
+		removedIDs[aws.ToString(inst.InstanceId)] = true
+	}
+	remaining := make([]types.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if !removedIDs[aws.ToString(inst.InstanceId)] {
+			remaining = append(remaining, inst)
+		}
+	}
+	return remaining
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStringSets(a, b []string) bool {
+	setA := make(map[string]bool, len(a))
+	for _, s := range a {
+		setA[s] = true
+	}
+	setB := make(map[string]bool, len(b))
+	for _, s := range b {
+		if !setA[s] {
+			return false
+		}
+		setB[s] = true
+	}
+	return len(setA) == len(setB)
+}
+
+func sortedStrings(list []string) []string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return sorted
+}
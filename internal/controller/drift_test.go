@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("detectInstanceDrift", func() {
+	var (
+		av   *ec2InstanceApplicableValues
+		tags map[string]string
+		inst types.Instance
+	)
+
+	BeforeEach(func() {
+		av = &ec2InstanceApplicableValues{
+			imageID:          "ami-1234abcd",
+			instanceType:     "t2.nano",
+			subnetIDs:        []string{"subnet-a", "subnet-b"},
+			securityGroupIDs: []string{"sg-a", "sg-b"},
+		}
+		tags = map[string]string{nameTagKey: "web", namespaceTagKey: "default", userDataHashTagKey: "hash-1"}
+		inst = types.Instance{
+			InstanceId:   aws.String("i-1"),
+			ImageId:      aws.String("ami-1234abcd"),
+			InstanceType: types.InstanceTypeT2Nano,
+			SubnetId:     aws.String("subnet-b"),
+			SecurityGroups: []types.GroupIdentifier{
+				{GroupId: aws.String("sg-b")},
+				{GroupId: aws.String("sg-a")},
+			},
+			Tags: []types.Tag{
+				{Key: aws.String(nameTagKey), Value: aws.String("web")},
+				{Key: aws.String(namespaceTagKey), Value: aws.String("default")},
+				{Key: aws.String(userDataHashTagKey), Value: aws.String("hash-1")},
+			},
+		}
+	})
+
+	It("should not report instances matching the spec", func() {
+		Expect(detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")).Should(BeEmpty())
+	})
+
+	It("should replace instances with a different image, type, subnet or security groups", func() {
+		inst.ImageId = aws.String("ami-old")
+		inst.InstanceType = types.InstanceTypeT2Micro
+		inst.SubnetId = aws.String("subnet-c")
+		inst.SecurityGroups = inst.SecurityGroups[:1]
+
+		drifts := detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")
+		Expect(drifts).Should(HaveLen(1))
+		Expect(drifts[0].reasons).Should(HaveLen(4))
+		Expect(drifts[0].action()).Should(Equal(v1alpha1.DriftActionReplaced))
+	})
+
+	It("should change the instance type in place when allowed", func() {
+		av.instanceType = "t3.small"
+		av.inPlaceTypeChange = true
+
+		drifts := detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")
+		Expect(drifts).Should(HaveLen(1))
+		Expect(drifts[0].changeInstanceType).Should(BeTrue())
+		Expect(drifts[0].action()).Should(Equal(v1alpha1.DriftActionUpdated))
+	})
+
+	It("should replace spot instances rather than change their type in place", func() {
+		av.instanceType = "t3.small"
+		av.inPlaceTypeChange = true
+		inst.InstanceLifecycle = types.InstanceLifecycleTypeSpot
+
+		drifts := detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")
+		Expect(drifts).Should(HaveLen(1))
+		Expect(drifts[0].changeInstanceType).Should(BeFalse())
+		Expect(drifts[0].action()).Should(Equal(v1alpha1.DriftActionReplaced))
+	})
+
+	It("should ignore fields that are not set in the spec", func() {
+		av.imageID = ""
+		av.subnetIDs = nil
+		inst.ImageId = aws.String("ami-from-template")
+		inst.SubnetId = aws.String("subnet-default")
+
+		Expect(detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")).Should(BeEmpty())
+	})
+
+	It("should update instances whose tags have drifted in place", func() {
+		tags["env"] = "prod"
+
+		drifts := detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "")
+		Expect(drifts).Should(HaveLen(1))
+		Expect(drifts[0].action()).Should(Equal(v1alpha1.DriftActionUpdated))
+		Expect(drifts[0].missingTags).Should(Equal(map[string]string{"env": "prod"}))
+	})
+
+	It("should replace every instance when the user data has changed", func() {
+		other := inst
+		other.InstanceId = aws.String("i-2")
+
+		drifts := detectInstanceDrift([]types.Instance{inst, other}, av, tags, "hash-2", "hash-1")
+		Expect(drifts).Should(HaveLen(2))
+		Expect(drifts[1].action()).Should(Equal(v1alpha1.DriftActionReplaced))
+	})
+
+	It("should compare untagged instances against the last applied user data", func() {
+		inst.Tags = inst.Tags[:2]
+
+		Expect(detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "hash-1")).Should(BeEmpty())
+		Expect(detectInstanceDrift([]types.Instance{inst}, av, tags, "hash-1", "hash-0")).Should(HaveLen(1))
+	})
+})
+
+var _ = Describe("removeInstances", func() {
+	It("should remove instances by ID", func() {
+		instances := []types.Instance{
+			{InstanceId: aws.String("i-1")},
+			{InstanceId: aws.String("i-2")},
+			{InstanceId: aws.String("i-3")},
+		}
+		remaining := removeInstances(instances, []types.Instance{{InstanceId: aws.String("i-2")}})
+		Expect(remaining).Should(HaveLen(2))
+		Expect(*remaining[1].InstanceId).Should(Equal("i-3"))
+	})
+})
@@ -18,27 +18,33 @@ package controller
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"reflect"
 	"time"
 
-	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
 	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	"github.com/kraken-iac/common/types/option"
 	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
 )
 
@@ -47,17 +53,33 @@ const (
 
 	nameTagKey      string = "kraken-name"
 	namespaceTagKey string = "kraken-namespace"
+	// userDataHashTagKey records the hash of the user data an instance was
+	// launched with
+	userDataHashTagKey string = "kraken-user-data-hash"
 
 	externalResourcePrefix string = "ec2instance"
 
-	conditionTypeReady string = "Ready"
+	conditionTypeReady                string = "Ready"
+	conditionTypeProgressing          string = "Progressing"
+	conditionTypeDependenciesResolved string = "DependenciesResolved"
+	conditionTypeInstancesLaunched    string = "InstancesLaunched"
+	conditionTypeInstancesHealthy     string = "InstancesHealthy"
+	conditionTypeStateDeclared        string = "StateDeclared"
+	conditionTypeSynced               string = "Synced"
+
+	spotTerminationStateReason string = "Server.SpotInstanceTermination"
+	spotShutdownStateReason    string = "Server.SpotInstanceShutdown"
 )
 
 type EC2InstanceClient interface {
 	RunInstances(ctx context.Context, params *ec2instanceclient.RunInstancesInput) (*ec2.RunInstancesOutput, error)
 	GetInstances(ctx context.Context, filterOptions ec2instanceclient.FilterOptions) ([]types.Instance, error)
-	WaitUntilRunning(ctx context.Context, filterOptions ec2instanceclient.FilterOptions, duration time.Duration) error
 	TerminateInstances(ctx context.Context, instances []types.Instance) (*ec2.TerminateInstancesOutput, error)
+	StopInstances(ctx context.Context, instances []types.Instance, hibernate bool) (*ec2.StopInstancesOutput, error)
+	StartInstances(ctx context.Context, instances []types.Instance) (*ec2.StartInstancesOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, params *ec2instanceclient.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error)
+	GetInstanceStatuses(ctx context.Context, instances []types.Instance) ([]types.InstanceStatus, error)
+	CreateTags(ctx context.Context, instances []types.Instance, tags map[string]string) error
 }
 
 // EC2InstanceReconciler reconciles a EC2Instance object
@@ -65,25 +87,51 @@ type EC2InstanceReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
-	EC2InstanceClient
+
+	// APIReader reads from the API server without the cache. It is used for
+	// Secrets so that they are not watched and cached cluster-wide.
+	APIReader client.Reader
+
+	EC2InstanceClients *EC2InstanceClientPool
+
+	// ResyncPeriod is the default interval at which ready resources are
+	// reconciled again to heal instances that were terminated or changed
+	// outside the operator. Zero disables periodic resyncs.
+	ResyncPeriod time.Duration
+
+	// LaunchTimeout is the default time launched instances may take to
+	// reach the running state before they are marked as failed
+	LaunchTimeout time.Duration
+
+	// PublishRawStateData adds the EC2 API's description of the instances
+	// and the spec to StateDeclarations alongside the versioned schema
+	PublishRawStateData bool
 }
 
 //+kubebuilder:rbac:groups=aws.kraken-iac.eoinfennessy.com,resources=ec2instances,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=aws.kraken-iac.eoinfennessy.com,resources=ec2instances/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=aws.kraken-iac.eoinfennessy.com,resources=ec2instances/finalizers,verbs=update
+//+kubebuilder:rbac:groups=aws.kraken-iac.eoinfennessy.com,resources=providerconfigs,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core.kraken-iac.eoinfennessy.com,resources=statedeclarations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core.kraken-iac.eoinfennessy.com,resources=dependencyrequests,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the EC2Instance object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile brings the EC2 instances tagged with the resource's name and
+// namespace in line with its spec and publishes them in a StateDeclaration.
 //
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
+// References to other resources are resolved through a DependencyRequest
+// first. Once they are resolved, status.observedGeneration is set to the
+// resource's generation and the instances are launched, updated, stopped or
+// terminated. Each stage is reported by a condition: DependenciesResolved,
+// InstancesLaunched, Progressing, InstancesHealthy and StateDeclared. Synced
+// is False whenever a reconcile stops before applying the spec, and Ready is
+// True once the desired state has been reached.
+//
+// Launches and rolling updates that are in progress are polled with
+// RequeueAfter. Transient AWS failures are retried with Requeue. Ready
+// resources are requeued after the resync period, or sooner when a schedule
+// window opens or closes.
 func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Reconcile triggered")
@@ -103,8 +151,8 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// Add initial status conditions if not present
 	if ec2Instance.Status.Conditions == nil || len(ec2Instance.Status.Conditions) == 0 {
 		log.Info("Setting initial status conditions for ec2Instance")
-		meta.SetStatusCondition(
-			&ec2Instance.Status.Conditions,
+		setStatusCondition(
+			ec2Instance,
 			metav1.Condition{
 				Type:    conditionTypeReady,
 				Status:  metav1.ConditionUnknown,
@@ -137,28 +185,65 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	// Handle deletion
 	if isMarkedForDeletion(ec2Instance) && controllerutil.ContainsFinalizer(ec2Instance, ec2InstanceFinalizer) {
-		log.Info("Performing finalizer operations for ec2Instance before deletion")
-
-		if err := r.doFinalizerOperations(ctx, req, ec2Instance); err != nil {
-			log.Error(err, "Failed to perform finalizer operations on ec2Instance")
-			return ctrl.Result{}, err
-		}
-
-		log.Info("Removing finalizer for EC2Instance")
-		if ok := controllerutil.RemoveFinalizer(ec2Instance, ec2InstanceFinalizer); !ok {
-			log.Info("Did not remove finalizer from ec2Instance as it is not present")
-			return ctrl.Result{Requeue: true}, nil
-		}
+		return r.finalize(ctx, req, ec2Instance)
+	}
 
-		if err := r.Update(ctx, ec2Instance); err != nil {
-			log.Error(err, "Failed to update ec2Instance after removing finalizer")
-			return ctrl.Result{}, err
-		}
-		return ctrl.Result{}, nil
+	// Get the EC2 client for the resource's region and ProviderConfig
+	clientConfig, err := r.getClientConfig(ctx, ec2Instance)
+	if err != nil {
+		log.Error(err, "Failed to resolve ProviderConfig")
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ProviderConfigError",
+				Message: fmt.Sprintf("Failed to resolve ProviderConfig: %s", err),
+			},
+		)
+		return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
+	}
+	ec2Client, err := r.EC2InstanceClients.Get(ctx, *clientConfig)
+	if err != nil {
+		log.Error(err, "Failed to create EC2 client", "region", clientConfig.Region, "providerConfig", clientConfig.ProviderConfig)
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ClientError",
+				Message: fmt.Sprintf("Failed to create EC2 client: %s", err),
+			},
+		)
+		return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
 	}
+	r.EC2InstanceClients.SetLastUsed(req.NamespacedName, ec2Client)
 
 	// Construct DependencyRequest spec
-	newDependencyRequestSpec := ec2Instance.Spec.GenerateDependencyRequestSpec()
+	newDependencyRequestSpec, err := ec2Instance.Spec.GenerateDependencyRequestSpec()
+	if err != nil {
+		log.Error(err, "Invalid references in spec")
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeDependenciesResolved,
+				Status:  metav1.ConditionFalse,
+				Reason:  "InvalidReference",
+				Message: err.Error(),
+			},
+		)
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "InvalidReference",
+				Message: err.Error(),
+			},
+		)
+		return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
+	}
+	dependencyRequestName := fmt.Sprintf("%s-%s", externalResourcePrefix, req.Name)
 
 	// Fetch existing DependencyRequest if one exists
 	oldDependencyRequest := &krakenv1alpha1.DependencyRequest{}
@@ -166,7 +251,7 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if err := r.Client.Get(
 		ctx,
 		client.ObjectKey{
-			Name:      fmt.Sprintf("%s-%s", externalResourcePrefix, req.Name),
+			Name:      dependencyRequestName,
 			Namespace: req.Namespace,
 		},
 		oldDependencyRequest,
@@ -196,7 +281,7 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if !oldDependencyRequestExists {
 			dependencyRequest := &krakenv1alpha1.DependencyRequest{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      fmt.Sprintf("%s-%s", externalResourcePrefix, req.Name),
+					Name:      dependencyRequestName,
 					Namespace: req.Namespace,
 				},
 				Spec: newDependencyRequestSpec,
@@ -219,8 +304,19 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				log.Error(err, "Error creating DependencyRequest", "name", dependencyRequest.ObjectMeta.Name)
 				return ctrl.Result{}, err
 			}
-			// TODO: Update status conditions to include DependencyRequest info
-			return ctrl.Result{}, nil
+			ec2Instance.Status.Dependencies = dependencyStatus(
+				ec2Instance.Spec, dependencyRequestName, krakenv1alpha1.DependentValues{}, false,
+			)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeDependenciesResolved,
+					Status:  metav1.ConditionFalse,
+					Reason:  "DependencyRequestCreated",
+					Message: fmt.Sprintf("Waiting for DependencyRequest %s to resolve references", dependencyRequestName),
+				},
+			)
+			return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
 		}
 
 		// Update DependencyRequest if old and new specs are different
@@ -233,8 +329,19 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				log.Error(err, "Could not update DependencyRequest", "name", updatedDependencyRequest.ObjectMeta.Name)
 				return ctrl.Result{}, err
 			}
-			// TODO: Update status conditions to include DependencyRequest info
-			return ctrl.Result{}, nil
+			ec2Instance.Status.Dependencies = dependencyStatus(
+				ec2Instance.Spec, dependencyRequestName, krakenv1alpha1.DependentValues{}, false,
+			)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeDependenciesResolved,
+					Status:  metav1.ConditionFalse,
+					Reason:  "DependencyRequestUpdated",
+					Message: fmt.Sprintf("Waiting for DependencyRequest %s to resolve references", dependencyRequestName),
+				},
+			)
+			return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
 		}
 
 		// Return without requeue if DependencyRequest is not ready
@@ -251,8 +358,20 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				log.Info("DependencyRequest's status conditions are not yet set")
 				return ctrl.Result{Requeue: true}, nil
 			}
-			meta.SetStatusCondition(
-				&ec2Instance.Status.Conditions,
+			ec2Instance.Status.Dependencies = dependencyStatus(
+				ec2Instance.Spec, dependencyRequestName, oldDependencyRequest.Status.DependentValues, false,
+			)
+			setStatusCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeDependenciesResolved,
+					Status:  metav1.ConditionFalse,
+					Reason:  "MissingDependency",
+					Message: dependencyRequestCondition.Message,
+				},
+			)
+			setFailedCondition(
+				ec2Instance,
 				metav1.Condition{
 					Type:    conditionTypeReady,
 					Status:  metav1.ConditionFalse,
@@ -268,8 +387,20 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	av, err := toApplicableValues(ec2Instance.Spec, oldDependencyRequest.Status.DependentValues)
 	if err != nil {
 		log.Error(err, "Could not construct applicable values")
-		meta.SetStatusCondition(
-			&ec2Instance.Status.Conditions,
+		ec2Instance.Status.Dependencies = dependencyStatus(
+			ec2Instance.Spec, dependencyRequestName, oldDependencyRequest.Status.DependentValues, false,
+		)
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeDependenciesResolved,
+				Status:  metav1.ConditionFalse,
+				Reason:  "DependencyError",
+				Message: fmt.Sprintf("Could not construct applicable values: %s", err),
+			},
+		)
+		setFailedCondition(
+			ec2Instance,
 			metav1.Condition{
 				Type:    conditionTypeReady,
 				Status:  metav1.ConditionFalse,
@@ -280,22 +411,111 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
 	}
 
-	// Get running and pending instances matching name and namespace tags
+	// Resolve user data referencing a Secret
+	if secretRef := userDataSecretRef(ec2Instance.Spec); secretRef != nil {
+		userData, err := r.getSecretValue(ctx, req.Namespace, secretRef)
+		if err != nil {
+			log.Error(err, "Could not retrieve user data from Secret", "name", secretRef.Name, "key", secretRef.Key)
+			ec2Instance.Status.Dependencies = dependencyStatus(
+				ec2Instance.Spec, dependencyRequestName, oldDependencyRequest.Status.DependentValues, false,
+			)
+			setStatusCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeDependenciesResolved,
+					Status:  metav1.ConditionFalse,
+					Reason:  "UserDataError",
+					Message: fmt.Sprintf("Could not retrieve user data from Secret: %s", err),
+				},
+			)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "UserDataError",
+					Message: fmt.Sprintf("Could not retrieve user data from Secret: %s", err),
+				},
+			)
+			return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
+		}
+		av.userData = userData
+	}
+
+	// The spec is applied from here on, so conditions set below are recorded
+	// against this generation
+	ec2Instance.Status.ObservedGeneration = ec2Instance.Generation
+	ec2Instance.Status.Dependencies = dependencyStatus(
+		ec2Instance.Spec, dependencyRequestName, oldDependencyRequest.Status.DependentValues, true,
+	)
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:    conditionTypeDependenciesResolved,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Resolved",
+			Message: "All referenced values have been resolved",
+		},
+	)
+
+	// Synced reports whether this reconcile applied the spec. It is set to
+	// false below if any step fails.
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:    conditionTypeSynced,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ReconcileSucceeded",
+			Message: "The spec has been applied",
+		},
+	)
+
+	// Apply the schedule whose window is currently open
+	activeSchedule, nextScheduleTime, err := evaluateSchedules(ec2Instance.Spec.Schedules, time.Now())
+	if err != nil {
+		log.Error(err, "Could not evaluate schedules")
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ScheduleError",
+				Message: fmt.Sprintf("Could not evaluate schedules: %s", err),
+			},
+		)
+		return ctrl.Result{}, r.Status().Update(ctx, ec2Instance)
+	}
+	desiredState := applySchedule(activeSchedule, av, desiredPowerState(ec2Instance.Spec))
+	activeScheduleName := ""
+	if activeSchedule != nil {
+		activeScheduleName = activeSchedule.Name
+	}
+	if activeScheduleName != ec2Instance.Status.ActiveSchedule {
+		log.Info("Active schedule changed", "from", ec2Instance.Status.ActiveSchedule, "to", activeScheduleName)
+		r.Recorder.Event(ec2Instance, "Normal", "ScheduleChanged",
+			fmt.Sprintf("Active schedule changed from %q to %q", ec2Instance.Status.ActiveSchedule, activeScheduleName),
+		)
+		ec2Instance.Status.ActiveSchedule = activeScheduleName
+	}
+	ec2Instance.Status.NextScheduleTime = nil
+	if !nextScheduleTime.IsZero() {
+		ec2Instance.Status.NextScheduleTime = &metav1.Time{Time: nextScheduleTime}
+	}
+
+	// Get instances matching name and namespace tags that have not been
+	// terminated. Stopped instances still belong to the set.
 	log.Info("Retrieving EC2 instances", "name", req.Name, "namespace", req.Namespace)
-	instances, err := r.EC2InstanceClient.GetInstances(ctx, ec2instanceclient.FilterOptions{
+	instances, err := ec2Client.GetInstances(ctx, ec2instanceclient.FilterOptions{
 		MatchTags: map[string]string{
 			nameTagKey:      req.Name,
 			namespaceTagKey: req.Namespace,
 		},
-		MatchStates: []types.InstanceStateName{
-			types.InstanceStateNamePending,
-			types.InstanceStateNameRunning,
-		},
+		MatchStates: inventoryInstanceStates,
 	})
 	if err != nil {
 		log.Error(err, "Failed to retrieve EC2 instances")
-		meta.SetStatusCondition(
-			&ec2Instance.Status.Conditions,
+		setFailedCondition(
+			ec2Instance,
 			metav1.Condition{
 				Type:    conditionTypeReady,
 				Status:  metav1.ConditionUnknown,
@@ -305,17 +525,178 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		)
 		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
 	}
+	// Record all instances in the status, but ignore those shutting down
+	setInstanceInventory(&ec2Instance.Status, instances, av.maxCount, desiredState)
+	ec2Instance.Status.Selector = instanceSelector(req)
+	instances = filterInstancesByState(instances, managedInstanceStates...)
+
+	// Check on instances launched by a previous reconcile
+	if ec2Instance.Status.PendingLaunch != nil {
+		waiting, timedOut, err := r.checkPendingLaunch(ctx, ec2Client, ec2Instance)
+		if err != nil {
+			log.Error(err, "Failed to check launched EC2 instances")
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "LaunchCheckFailed",
+					Message: fmt.Sprintf("Failed to check launched EC2 instances: %s", err),
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+		if waiting {
+			log.Info("Waiting for launched EC2 instances to reach the running state")
+			setStatusCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeInstancesLaunched,
+					Status:  metav1.ConditionFalse,
+					Reason:  "Launching",
+					Message: fmt.Sprintf("Waiting for %d launched instance(s) to reach the running state", len(ec2Instance.Status.PendingLaunch.InstanceIDs)),
+				},
+			)
+			return ctrl.Result{RequeueAfter: launchPollInterval}, r.Status().Update(ctx, ec2Instance)
+		}
+		instances = removeInstances(instances, timedOut)
+	}
+
+	// Update drifted instances in place where possible; the rest are out of
+	// date and replaced according to the update strategy
+	userDataHash := hashUserData(av.userData)
+	tags := makeInstanceTags(req, ec2Instance.Spec.Tags)
+	tags[userDataHashTagKey] = userDataHash
+	ec2Instance.Status.DriftedInstances = nil
+	var outdated, resized []types.Instance
+	if drifts := detectInstanceDrift(instances, av, tags, userDataHash, ec2Instance.Status.UserDataHash); len(drifts) > 0 {
+		log.Info("Detected drifted EC2 instances", "instanceCount", len(drifts))
+
+		for _, d := range drifts {
+			ec2Instance.Status.DriftedInstances = append(ec2Instance.Status.DriftedInstances, ec2instancev1alpha1.InstanceDrift{
+				InstanceID: aws.ToString(d.instance.InstanceId),
+				Reasons:    d.reasons,
+				Action:     d.action(),
+			})
+			if d.replace {
+				outdated = append(outdated, d.instance)
+				continue
+			}
+			if d.changeInstanceType {
+				resized = append(resized, d.instance)
+			}
+			if len(d.missingTags) == 0 {
+				continue
+			}
+			if err := ec2Client.CreateTags(ctx, []types.Instance{d.instance}, d.missingTags); err != nil {
+				log.Error(err, "Failed to update tags on drifted EC2 instance", "instanceID", aws.ToString(d.instance.InstanceId))
+				setFailedCondition(
+					ec2Instance,
+					metav1.Condition{
+						Type:    conditionTypeReady,
+						Status:  metav1.ConditionFalse,
+						Reason:  "DriftUpdateFailed",
+						Message: fmt.Sprintf("Failed to update drifted EC2 instance: %s", err),
+					},
+				)
+				return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+			}
+		}
+
+		r.Recorder.Event(ec2Instance, "Normal", "DriftDetected",
+			fmt.Sprintf("%d instance(s) drifted from the spec: %d out of date, %d updated in place",
+				len(drifts), len(outdated), len(drifts)-len(outdated)),
+		)
+	}
+
+	// Change the instance type in place over several reconciles. During a
+	// rolling update, instances are stopped in batches of maxUnavailable as
+	// each is unavailable while stopped.
+	if len(resized) > 0 {
+		limit := updateStrategyType(ec2Instance.Spec.UpdateStrategy) == ec2instancev1alpha1.RollingUpdateStrategyType
+		_, maxUnavailable := rollingUpdateLimits(ec2Instance.Spec.UpdateStrategy, av.maxCount)
+		statuses, err := ec2Client.GetInstanceStatuses(ctx, instances)
+		if err != nil {
+			log.Error(err, "Failed to retrieve EC2 instance statuses")
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionUnknown,
+					Reason:  "RetrievalFailed",
+					Message: "Failed to retrieve EC2 instance statuses",
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+		plan := planInstanceTypeChange(resized, availableInstanceIDs(statuses), len(instances), maxUnavailable, limit)
+
+		log.Info("Changing instance type of EC2 instances",
+			"stopping", len(plan.stop), "changing", len(plan.modify), "instanceType", av.instanceType)
+		restart := desiredState == ec2instancev1alpha1.DesiredStateRunning
+		if err := applyInstanceTypeChange(ctx, ec2Client, plan, av.instanceType, restart); err != nil {
+			log.Error(err, "Failed to change instance type of EC2 instances")
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "InstanceTypeChangeFailed",
+					Message: fmt.Sprintf("Failed to change instance type: %s", err),
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+		if len(plan.modify) > 0 {
+			r.Recorder.Event(ec2Instance, "Normal", "InstanceTypeChanged",
+				fmt.Sprintf("Changed the instance type of %d instance(s) to %s", len(plan.modify), av.instanceType),
+			)
+		}
+
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:   conditionTypeProgressing,
+				Status: metav1.ConditionTrue,
+				Reason: "InstanceTypeChange",
+				Message: fmt.Sprintf("Changing the instance type of %d instance(s): %d stopping, %d changed",
+					len(resized), len(plan.stop)+plan.transitioning, len(plan.modify)),
+			},
+		)
+		return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.Status().Update(ctx, ec2Instance)
+	}
+
+	if len(outdated) > 0 {
+		if updateStrategyType(ec2Instance.Spec.UpdateStrategy) == ec2instancev1alpha1.RollingUpdateStrategyType {
+			return r.rollingUpdate(ctx, ec2Client, ec2Instance, av, instances, outdated, tags)
+		}
 
-	// TODO: compare all instances to spec and either update (if possible) or terminate those that do not match (update list)
+		log.Info("Terminating out-of-date EC2 instances", "instanceCount", len(outdated))
+		if _, err := ec2Client.TerminateInstances(ctx, outdated); err != nil {
+			log.Error(err, "Failed to terminate out-of-date EC2 instances")
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "TerminateFailed",
+					Message: "Failed to replace out-of-date EC2 instances",
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+		instances = removeInstances(instances, outdated)
+	}
 
-	// Scale down
+	// Scale down, terminating broken instances first and keeping subnets
+	// balanced
 	if len(instances) > av.maxCount {
 		log.Info("Scaling down EC2 instances")
-		terminationCount := len(instances) - av.maxCount
-		if _, err := r.EC2InstanceClient.TerminateInstances(ctx, instances[:terminationCount]); err != nil {
+		terminated := selectScaleDownInstances(instances, len(instances)-av.maxCount, desiredState)
+		if _, err := ec2Client.TerminateInstances(ctx, terminated); err != nil {
 			log.Error(err, "Failed to terminate EC2 instances")
-			meta.SetStatusCondition(
-				&ec2Instance.Status.Conditions,
+			setFailedCondition(
+				ec2Instance,
 				metav1.Condition{
 					Type:    conditionTypeReady,
 					Status:  metav1.ConditionFalse,
@@ -323,8 +704,9 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					Message: "Failed to scale down EC2 instances",
 				},
 			)
-			return ctrl.Result{Requeue: true}, r.Update(ctx, ec2Instance)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
 		}
+		instances = removeInstances(instances, terminated)
 	}
 
 	// Scale up
@@ -337,76 +719,89 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			av.minCount,
 		)
 
-		tags := makeInstanceTags(req, ec2Instance.Spec.Tags)
+		clientToken, placements, err := r.reserveLaunch(
+			ctx, ec2Instance, planSubnetPlacements(instances, av.subnetIDs, maxCount, minCount),
+		)
+		if err != nil {
+			log.Error(err, "Failed to record launch client token")
+			return ctrl.Result{}, err
+		}
 
-		o, err := r.EC2InstanceClient.RunInstances(ctx, &ec2instanceclient.RunInstancesInput{
-			MaxCount:     maxCount,
-			MinCount:     minCount,
-			ImageID:      av.imageID,
-			InstanceType: av.instanceType,
-			Tags:         tags,
-		})
+		launchedIDs, err := launchInstances(ctx, ec2Client, av, placements, tags, clientToken)
 		if err != nil {
 			log.Error(err, "Failed to run instances")
-			meta.SetStatusCondition(
-				&ec2Instance.Status.Conditions,
+			recordFailedLaunch(&ec2Instance.Status, launchedIDs, err)
+			setStatusCondition(
+				ec2Instance,
 				metav1.Condition{
-					Type:    conditionTypeReady,
+					Type:    conditionTypeInstancesLaunched,
 					Status:  metav1.ConditionFalse,
 					Reason:  "RunFailed",
-					Message: "Failed to scale up EC2 instances",
+					Message: fmt.Sprintf("Failed to run instances: %s", err),
 				},
 			)
-			return ctrl.Result{Requeue: true}, r.Update(ctx, ec2Instance)
-		}
-		log.Info("Created instances", "instanceCount", len(o.Instances))
-
-		// Wait for pending instances to reach running state
-		log.Info("Waiting for pending instances to reach running state")
-		if err := r.WaitUntilRunning(
-			ctx,
-			ec2instanceclient.FilterOptions{
-				MatchTags: map[string]string{
-					nameTagKey:      req.Name,
-					namespaceTagKey: req.Namespace,
-				},
-				MatchStates: []types.InstanceStateName{
-					types.InstanceStateNamePending,
-					types.InstanceStateNameRunning,
-				},
-			},
-			// TODO: Make this time configurable
-			time.Minute*2,
-		); err != nil {
-			log.Error(err, "Encountered error waiting for running state")
-			meta.SetStatusCondition(
-				&ec2Instance.Status.Conditions,
+			setFailedCondition(
+				ec2Instance,
 				metav1.Condition{
 					Type:    conditionTypeReady,
 					Status:  metav1.ConditionFalse,
-					Reason:  "WaitForRunningError",
-					Message: fmt.Sprintf("Encountered error waiting for running state: %s", err),
+					Reason:  "RunFailed",
+					Message: "Failed to scale up EC2 instances",
 				},
 			)
 			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
 		}
+		log.Info("Created instances", "instanceCount", len(launchedIDs))
+
+		// Check on the launched instances in later reconciles rather than
+		// blocking until they reach the running state
+		recordLaunch(&ec2Instance.Status, launchedIDs)
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeProgressing,
+				Status:  metav1.ConditionTrue,
+				Reason:  "Launching",
+				Message: fmt.Sprintf("Waiting for %d launched instance(s) to reach the running state", len(launchedIDs)),
+			},
+		)
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeInstancesLaunched,
+				Status:  metav1.ConditionFalse,
+				Reason:  "Launching",
+				Message: fmt.Sprintf("Waiting for %d launched instance(s) to reach the running state", len(launchedIDs)),
+			},
+		)
+		return ctrl.Result{RequeueAfter: launchPollInterval}, r.Status().Update(ctx, ec2Instance)
 	}
+	// No launch is needed, so any token left by an unrecorded launch is stale
+	ec2Instance.Status.LaunchClientToken = ""
+	ec2Instance.Status.LaunchPlacements = nil
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:    conditionTypeInstancesLaunched,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Launched",
+			Message: fmt.Sprintf("%d instance(s) have been launched", len(instances)),
+		},
+	)
 
-	// Retrieve running instances to use in StateDeclaration data
-	log.Info("Retrieving running EC2 instances", "name", req.Name, "namespace", req.Namespace)
-	instances, err = r.EC2InstanceClient.GetInstances(ctx, ec2instanceclient.FilterOptions{
+	// Retrieve instances to converge their power state
+	log.Info("Retrieving EC2 instances", "name", req.Name, "namespace", req.Namespace)
+	instances, err = ec2Client.GetInstances(ctx, ec2instanceclient.FilterOptions{
 		MatchTags: map[string]string{
 			nameTagKey:      req.Name,
 			namespaceTagKey: req.Namespace,
 		},
-		MatchStates: []types.InstanceStateName{
-			types.InstanceStateNameRunning,
-		},
+		MatchStates: inventoryInstanceStates,
 	})
 	if err != nil {
 		log.Error(err, "Failed to retrieve EC2 instances")
-		meta.SetStatusCondition(
-			&ec2Instance.Status.Conditions,
+		setFailedCondition(
+			ec2Instance,
 			metav1.Condition{
 				Type:    conditionTypeReady,
 				Status:  metav1.ConditionUnknown,
@@ -416,9 +811,76 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		)
 		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
 	}
+	// Record all instances in the status, but ignore those shutting down
+	setInstanceInventory(&ec2Instance.Status, instances, av.maxCount, desiredState)
+	instances = filterInstancesByState(instances, managedInstanceStates...)
+
+	// Start or stop instances to reach the desired power state
+	if plan := planPowerStateChanges(instances, desiredState); !plan.converged() {
+		if err := applyPowerStateChanges(ctx, ec2Client, plan, desiredState); err != nil {
+			log.Error(err, "Failed to change power state of EC2 instances", "desiredState", desiredState)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "PowerStateChangeFailed",
+					Message: fmt.Sprintf("Failed to change power state of EC2 instances: %s", err),
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+		if len(plan.start) > 0 || len(plan.stop) > 0 {
+			r.Recorder.Event(ec2Instance, "Normal", "PowerStateChange",
+				fmt.Sprintf("Started %d and stopped %d instance(s) to reach the %s state", len(plan.start), len(plan.stop), desiredState),
+			)
+		}
+
+		log.Info("Waiting for EC2 instances to reach the desired power state", "desiredState", desiredState)
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeProgressing,
+				Status:  metav1.ConditionTrue,
+				Reason:  "PowerStateChange",
+				Message: fmt.Sprintf("Waiting for instances to reach the %s state", desiredState),
+			},
+		)
+		return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.Status().Update(ctx, ec2Instance)
+	}
+
+	// Use only instances in the desired power state in StateDeclaration data
+	instances = filterInstancesByState(instances, steadyInstanceState(desiredState))
+
+	// Count instances passing status checks
+	statuses, err := ec2Client.GetInstanceStatuses(ctx, instances)
+	if err != nil {
+		log.Error(err, "Failed to retrieve EC2 instance statuses")
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionUnknown,
+				Reason:  "RetrievalFailed",
+				Message: "Failed to retrieve EC2 instance statuses",
+			},
+		)
+		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+	}
+	ec2Instance.Status.UpdatedInstances = int32(len(instances))
+	ec2Instance.Status.AvailableInstances = int32(len(availableInstanceIDs(statuses)))
+	setStatusCondition(
+		ec2Instance,
+		instancesHealthyCondition(desiredState, len(instances), int(ec2Instance.Status.AvailableInstances)),
+	)
 
 	// Construct StateDeclaration data
-	stateDeclarationData, err := constructStateDeclarationData(*ec2Instance, instances)
+	stateDeclarationData, err := constructStateDeclarationData(
+		*ec2Instance,
+		instances,
+		r.EC2InstanceClients.Region(*clientConfig),
+		r.PublishRawStateData,
+	)
 	if err != nil {
 		log.Error(err, "Could not convert to StateDeclaration data")
 		return ctrl.Result{}, err
@@ -449,8 +911,17 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return nil
 		}); err != nil {
 		log.Error(err, "Failed to create or update StateDeclaration")
-		meta.SetStatusCondition(
-			&ec2Instance.Status.Conditions,
+		setStatusCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeStateDeclared,
+				Status:  metav1.ConditionFalse,
+				Reason:  "StateDeclarationError",
+				Message: fmt.Sprintf("Failed to create/update StateDeclaration: %s", err),
+			},
+		)
+		setFailedCondition(
+			ec2Instance,
 			metav1.Condition{
 				Type:    conditionTypeReady,
 				Status:  metav1.ConditionFalse,
@@ -462,10 +933,29 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	} else {
 		log.Info("Created/updated StateDeclaration", "operationResult", string(result))
 	}
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:    conditionTypeStateDeclared,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Declared",
+			Message: fmt.Sprintf("StateDeclaration %s is up to date", stateDeclaration.Name),
+		},
+	)
 
 	// Update status condition type ready to true
-	meta.SetStatusCondition(
-		&ec2Instance.Status.Conditions,
+	ec2Instance.Status.UserDataHash = userDataHash
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:    conditionTypeProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RolloutComplete",
+			Message: "All instances match the spec",
+		},
+	)
+	setStatusCondition(
+		ec2Instance,
 		metav1.Condition{
 			Type:    conditionTypeReady,
 			Status:  metav1.ConditionTrue,
@@ -473,22 +963,87 @@ func (r *EC2InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			Message: "Desired state has been reached",
 		},
 	)
-	// TODO: If no change, add time to requeue for self-heal check to ensure state remains as desired.
 	if err := r.Status().Update(ctx, ec2Instance); err != nil {
 		log.Error(err, "Failed to update ec2Instance status")
 		return ctrl.Result{}, err
 	}
 
+	// Requeue to re-check instances against the spec, or sooner when the next
+	// schedule window opens or closes
+	return ctrl.Result{
+		RequeueAfter: requeueAfter(r.resyncPeriod(ec2Instance.Spec), nextScheduleTime, time.Now()),
+	}, nil
+}
+
+// finalize terminates the resource's instances and removes its finalizer. If
+// the ProviderConfig or its credentials were deleted first, as happens when a
+// namespace is deleted, the client last used for the resource is used. If
+// there is none, as after a manager restart, the finalizer is kept and
+// termination is retried until the credentials are available again. The
+// finalizer can be removed by hand to abandon the instances.
+func (r *EC2InstanceReconciler) finalize(
+	ctx context.Context, req ctrl.Request, ec2Instance *ec2instancev1alpha1.EC2Instance,
+) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	log.Info("Performing finalizer operations for ec2Instance before deletion")
+
+	var ec2Client EC2InstanceClient
+	clientConfig, err := r.getClientConfig(ctx, ec2Instance)
+	if err == nil {
+		ec2Client, err = r.EC2InstanceClients.Get(ctx, *clientConfig)
+	}
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to create EC2 client")
+			return ctrl.Result{}, err
+		}
+		if lastUsed, exists := r.EC2InstanceClients.LastUsed(req.NamespacedName); exists {
+			log.Info("ProviderConfig no longer exists; using the last client used for ec2Instance")
+			ec2Client = lastUsed
+		} else {
+			log.Error(err, "Cannot terminate EC2 instances without credentials; retrying")
+			r.Recorder.Event(ec2Instance, "Warning", "CredentialsUnavailable",
+				fmt.Sprintf("EC2 instances cannot be terminated until credentials are available: %s", err),
+			)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "CredentialsUnavailable",
+					Message: fmt.Sprintf("EC2 instances cannot be terminated until credentials are available: %s", err),
+				},
+			)
+			return ctrl.Result{RequeueAfter: credentialsRetryInterval}, r.Status().Update(ctx, ec2Instance)
+		}
+	}
+
+	if err := r.doFinalizerOperations(ctx, req, ec2Instance, ec2Client); err != nil {
+		log.Error(err, "Failed to perform finalizer operations on ec2Instance")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Removing finalizer for EC2Instance")
+	if ok := controllerutil.RemoveFinalizer(ec2Instance, ec2InstanceFinalizer); !ok {
+		log.Info("Did not remove finalizer from ec2Instance as it is not present")
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if err := r.Update(ctx, ec2Instance); err != nil {
+		log.Error(err, "Failed to update ec2Instance after removing finalizer")
+		return ctrl.Result{}, err
+	}
+	r.EC2InstanceClients.ForgetLastUsed(req.NamespacedName)
 	return ctrl.Result{}, nil
 }
 
 func (r *EC2InstanceReconciler) doFinalizerOperations(
-	ctx context.Context, req ctrl.Request, ec2Instance *ec2instancev1alpha1.EC2Instance,
+	ctx context.Context, req ctrl.Request, ec2Instance *ec2instancev1alpha1.EC2Instance, ec2Client EC2InstanceClient,
 ) error {
 	log := log.FromContext(ctx)
 
 	log.Info("Retrieving EC2 instances")
-	instances, err := r.EC2InstanceClient.GetInstances(ctx, ec2instanceclient.FilterOptions{
+	instances, err := ec2Client.GetInstances(ctx, ec2instanceclient.FilterOptions{
 		MatchTags: map[string]string{
 			nameTagKey:      req.Name,
 			namespaceTagKey: req.Namespace,
@@ -501,7 +1056,7 @@ func (r *EC2InstanceReconciler) doFinalizerOperations(
 
 	if len(instances) > 0 {
 		log.Info("Terminating EC2 instances")
-		if _, err := r.EC2InstanceClient.TerminateInstances(ctx, instances); err != nil {
+		if _, err := ec2Client.TerminateInstances(ctx, instances); err != nil {
 			log.Error(err, "Failed to terminate EC2 instances")
 			return err
 		}
@@ -515,15 +1070,80 @@ func (r *EC2InstanceReconciler) doFinalizerOperations(
 	return nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. Changes to a
+// ProviderConfig or its credentials Secret reconcile the EC2Instances using
+// it so that new credentials take effect immediately. Only the metadata of
+// Secrets is cached.
 func (r *EC2InstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&ec2instancev1alpha1.EC2Instance{}).
 		Owns(&krakenv1alpha1.StateDeclaration{}).
 		Owns(&krakenv1alpha1.DependencyRequest{}).
+		Watches(
+			&ec2instancev1alpha1.ProviderConfig{},
+			handler.EnqueueRequestsFromMapFunc(r.ec2InstancesForProviderConfig),
+		).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.ec2InstancesForSecret),
+			builder.OnlyMetadata,
+		).
 		Complete(r)
 }
 
+func isSpotInterrupted(inst types.Instance) bool {
+	if inst.InstanceLifecycle != types.InstanceLifecycleTypeSpot || inst.StateReason == nil || inst.StateReason.Code == nil {
+		return false
+	}
+	switch *inst.StateReason.Code {
+	case spotTerminationStateReason, spotShutdownStateReason:
+		return true
+	}
+	return false
+}
+
+// launchInstances launches instances into each of the placements and returns
+// the IDs of the instances launched. Each placement is launched with a client
+// token derived from clientToken.
+func launchInstances(
+	ctx context.Context,
+	ec2Client EC2InstanceClient,
+	av *ec2InstanceApplicableValues,
+	placements []subnetPlacement,
+	tags map[string]string,
+	clientToken string,
+) ([]string, error) {
+	var launchedIDs []string
+	for i, placement := range placements {
+		o, err := ec2Client.RunInstances(ctx, &ec2instanceclient.RunInstancesInput{
+			MaxCount:           placement.maxCount,
+			MinCount:           placement.minCount,
+			ImageID:            av.imageID,
+			InstanceType:       av.instanceType,
+			LaunchTemplate:     av.launchTemplate,
+			SubnetID:           placement.subnetID,
+			SecurityGroupIDs:   av.securityGroupIDs,
+			KeyName:            av.keyName,
+			IAMInstanceProfile: av.iamInstanceProfile,
+			SpotOptions:        av.spotOptions,
+			MetadataOptions:    av.metadataOptions,
+			RootVolume:         av.rootVolume,
+			DataVolumes:        av.dataVolumes,
+			Hibernation:        av.hibernation,
+			UserData:           encodeUserData(av.userData),
+			Tags:               tags,
+			ClientToken:        fmt.Sprintf("%s-%d", clientToken, i),
+		})
+		if err != nil {
+			return launchedIDs, fmt.Errorf("subnet %q: %w", placement.subnetID, err)
+		}
+		for _, inst := range o.Instances {
+			launchedIDs = append(launchedIDs, aws.ToString(inst.InstanceId))
+		}
+	}
+	return launchedIDs, nil
+}
+
 func adjustMaxMinInstanceCount(current, max, min int) (newMax, newMin int) {
 	newMax = max - current
 	if min-current < 1 {
@@ -534,6 +1154,97 @@ func adjustMaxMinInstanceCount(current, max, min int) (newMax, newMin int) {
 	return newMax, newMin
 }
 
+type subnetPlacement struct {
+	subnetID string
+	maxCount int
+	minCount int
+}
+
+// planSubnetPlacements splits a launch of up to maxCount instances across the
+// given subnets, favouring the subnets that currently hold the fewest
+// instances. With zero or one subnet a single placement is returned.
+func planSubnetPlacements(instances []types.Instance, subnetIDs []string, maxCount, minCount int) []subnetPlacement {
+	if len(subnetIDs) <= 1 {
+		placement := subnetPlacement{maxCount: maxCount, minCount: minCount}
+		if len(subnetIDs) == 1 {
+			placement.subnetID = subnetIDs[0]
+		}
+		return []subnetPlacement{placement}
+	}
+
+	counts := make(map[string]int, len(subnetIDs))
+	for _, inst := range instances {
+		if inst.SubnetId != nil {
+			counts[*inst.SubnetId]++
+		}
+	}
+
+	allocations := make([]int, len(subnetIDs))
+	for n := 0; n < maxCount; n++ {
+		target := 0
+		for i, subnetID := range subnetIDs {
+			if counts[subnetID] < counts[subnetIDs[target]] {
+				target = i
+			}
+		}
+		allocations[target]++
+		counts[subnetIDs[target]]++
+	}
+
+	// Each subnet is launched into independently, so any shortfall in one
+	// subnet is made up on a later reconcile rather than failing the others.
+	placements := make([]subnetPlacement, 0, len(subnetIDs))
+	for i, subnetID := range subnetIDs {
+		if allocations[i] == 0 {
+			continue
+		}
+		placements = append(placements, subnetPlacement{
+			subnetID: subnetID,
+			maxCount: allocations[i],
+			minCount: 1,
+		})
+	}
+	return placements
+}
+
+func userDataSecretRef(spec ec2instancev1alpha1.EC2InstanceSpec) *option.ValueFromSecret {
+	if spec.UserData.Value != nil || spec.UserData.ValueFrom == nil {
+		return nil
+	}
+	return spec.UserData.ValueFrom.Secret
+}
+
+func (r *EC2InstanceReconciler) getSecretValue(
+	ctx context.Context, namespace string, secretRef *option.ValueFromSecret,
+) (*string, error) {
+	secret := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Name: secretRef.Name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+	data, exists := secret.Data[secretRef.Key]
+	if !exists {
+		return nil, fmt.Errorf("key \"%s\" does not exist in Secret \"%s\"", secretRef.Key, secretRef.Name)
+	}
+	value := string(data)
+	return &value, nil
+}
+
+// encodeUserData base64-encodes user data as required by RunInstances
+func encodeUserData(userData *string) string {
+	if userData == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(*userData))
+}
+
+func hashUserData(userData *string) string {
+	if userData == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(*userData))
+	return hex.EncodeToString(sum[:])
+}
+
 func makeInstanceTags(req reconcile.Request, specTags map[string]string) map[string]string {
 	tags := make(map[string]string, len(specTags)+2)
 	for tagKey, tagVal := range specTags {
@@ -547,18 +1258,3 @@ func makeInstanceTags(req reconcile.Request, specTags map[string]string) map[str
 func isMarkedForDeletion(ec2Instance *ec2instancev1alpha1.EC2Instance) bool {
 	return ec2Instance.DeletionTimestamp != nil
 }
-
-func constructStateDeclarationData(ec2Instance ec2instancev1alpha1.EC2Instance, instances []types.Instance) (*v1.JSON, error) {
-	dataMap := make(map[string]interface{})
-	dataMap["instances"] = instances
-	dataMap["spec"] = ec2Instance.Spec
-
-	dataJSON, err := json.Marshal(dataMap)
-	if err != nil {
-		return nil, err
-	}
-
-	stateDeclarationData := v1.JSON{}
-	stateDeclarationData.Raw = dataJSON
-	return &stateDeclarationData, nil
-}
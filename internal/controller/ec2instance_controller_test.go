@@ -2,16 +2,22 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
 	mockec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/mock_ec2instance_client"
 	"github.com/kraken-iac/common/types/option"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -46,9 +52,15 @@ var _ = Describe("EC2Instance controller", func() {
 			fakeClient = fake.NewClientBuilder().Build()
 			fakeEC2InstanceClient = mockec2instanceclient.MockEC2InstanceClient{}
 			r = &EC2InstanceReconciler{
-				Client:            fakeClient,
-				Scheme:            scheme.Scheme,
-				EC2InstanceClient: fakeEC2InstanceClient,
+				Client:    fakeClient,
+				Scheme:    scheme.Scheme,
+				APIReader: fakeClient,
+				EC2InstanceClients: NewEC2InstanceClientPool(
+					"us-east-1",
+					func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+						return fakeEC2InstanceClient, nil
+					},
+				),
 			}
 			ec2Instance = &v1alpha1.EC2Instance{
 				ObjectMeta: v1.ObjectMeta{
@@ -94,3 +106,141 @@ var _ = Describe("EC2Instance controller", func() {
 		})
 	})
 })
+
+var _ = Describe("planSubnetPlacements", func() {
+	subnetA, subnetB, subnetC := "subnet-a", "subnet-b", "subnet-c"
+
+	It("should return a single placement when no subnets are given", func() {
+		placements := planSubnetPlacements(nil, nil, 3, 1)
+		Expect(placements).Should(Equal([]subnetPlacement{{maxCount: 3, minCount: 1}}))
+	})
+
+	It("should return a single placement for a single subnet", func() {
+		placements := planSubnetPlacements(nil, []string{subnetA}, 2, 2)
+		Expect(placements).Should(Equal([]subnetPlacement{{subnetID: subnetA, maxCount: 2, minCount: 2}}))
+	})
+
+	It("should favour the subnets holding the fewest instances", func() {
+		existing := []ec2types.Instance{
+			{SubnetId: &subnetA},
+			{SubnetId: &subnetA},
+			{SubnetId: &subnetB},
+		}
+		placements := planSubnetPlacements(existing, []string{subnetA, subnetB, subnetC}, 3, 1)
+		Expect(placements).Should(Equal([]subnetPlacement{
+			{subnetID: subnetB, maxCount: 1, minCount: 1},
+			{subnetID: subnetC, maxCount: 2, minCount: 1},
+		}))
+	})
+})
+
+var _ = Describe("user data", func() {
+	userData := "#cloud-config\npackages:\n  - nginx\n"
+
+	It("should base64-encode user data for RunInstances", func() {
+		Expect(encodeUserData(&userData)).Should(Equal("I2Nsb3VkLWNvbmZpZwpwYWNrYWdlczoKICAtIG5naW54Cg=="))
+		Expect(encodeUserData(nil)).Should(BeEmpty())
+	})
+
+	It("should produce a hash that changes with the user data", func() {
+		changed := userData + "  - curl\n"
+		Expect(hashUserData(&userData)).Should(HaveLen(64))
+		Expect(hashUserData(&userData)).ShouldNot(Equal(hashUserData(&changed)))
+		Expect(hashUserData(nil)).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("isSpotInterrupted", func() {
+	terminationCode, userCode := spotTerminationStateReason, "Client.UserInitiatedShutdown"
+
+	It("should detect spot instances terminated by an interruption", func() {
+		inst := ec2types.Instance{
+			InstanceLifecycle: ec2types.InstanceLifecycleTypeSpot,
+			StateReason:       &ec2types.StateReason{Code: &terminationCode},
+		}
+		Expect(isSpotInterrupted(inst)).Should(BeTrue())
+	})
+
+	It("should ignore user-initiated shutdowns and on-demand instances", func() {
+		Expect(isSpotInterrupted(ec2types.Instance{
+			InstanceLifecycle: ec2types.InstanceLifecycleTypeSpot,
+			StateReason:       &ec2types.StateReason{Code: &userCode},
+		})).Should(BeFalse())
+		Expect(isSpotInterrupted(ec2types.Instance{
+			StateReason: &ec2types.StateReason{Code: &terminationCode},
+		})).Should(BeFalse())
+	})
+})
+
+// terminateFailingClient fails all terminations
+type terminateFailingClient struct {
+	*fakeEC2Client
+}
+
+func (c terminateFailingClient) TerminateInstances(ctx context.Context, instances []ec2types.Instance) (*ec2.TerminateInstancesOutput, error) {
+	return nil, errors.New("unauthorized")
+}
+
+var _ = Describe("Reconcile scale down", func() {
+	It("should record a failed scale down in the status", func() {
+		ctx := context.Background()
+		testScheme := newTestScheme()
+
+		imageID, instanceType, count := "ami-1234abcd", "t2.nano", 2
+		ec2Instance := &v1alpha1.EC2Instance{
+			ObjectMeta: v1.ObjectMeta{Name: "test-ec2instance", Namespace: "default"},
+			Spec: v1alpha1.EC2InstanceSpec{
+				ImageID:      option.String{Value: &imageID},
+				InstanceType: option.String{Value: &instanceType},
+				MaxCount:     option.Int{Value: &count},
+				MinCount:     option.Int{Value: &count},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(ec2Instance).
+			WithStatusSubresource(ec2Instance).
+			Build()
+		ec2Client := &fakeEC2Client{}
+		r := &EC2InstanceReconciler{
+			Client:    fakeClient,
+			Scheme:    testScheme,
+			Recorder:  record.NewFakeRecorder(10),
+			APIReader: fakeClient,
+			EC2InstanceClients: NewEC2InstanceClientPool(
+				"us-east-1",
+				func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+					return ec2Client, nil
+				},
+			),
+		}
+		req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(ec2Instance)}
+		for i := 0; i < 3; i++ {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+		}
+		ec2Client.setState(ec2types.InstanceStateNameRunning)
+
+		By("scaling down with a client that cannot terminate instances")
+		Expect(fakeClient.Get(ctx, req.NamespacedName, ec2Instance)).To(Succeed())
+		one := 1
+		ec2Instance.Spec.MaxCount = option.Int{Value: &one}
+		ec2Instance.Spec.MinCount = option.Int{Value: &one}
+		Expect(fakeClient.Update(ctx, ec2Instance)).To(Succeed())
+		r.EC2InstanceClients = NewEC2InstanceClientPool(
+			"us-east-1",
+			func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+				return terminateFailingClient{ec2Client}, nil
+			},
+		)
+
+		result, err := r.Reconcile(ctx, req)
+		Expect(err).Should(BeNil())
+		Expect(result.Requeue).Should(BeTrue())
+		Expect(fakeClient.Get(ctx, req.NamespacedName, ec2Instance)).To(Succeed())
+		ready := meta.FindStatusCondition(ec2Instance.Status.Conditions, conditionTypeReady)
+		Expect(ready).ShouldNot(BeNil())
+		Expect(ready.Reason).Should(Equal("TerminateFailed"))
+		Expect(meta.IsStatusConditionFalse(ec2Instance.Status.Conditions, conditionTypeSynced)).Should(BeTrue())
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+)
+
+type instanceTypeChangePlan struct {
+	// stop are running instances to stop so that their type can be changed
+	stop []types.Instance
+
+	// modify are stopped instances whose type can be changed
+	modify []types.Instance
+
+	// transitioning is the number of instances that must finish starting or
+	// stopping before their type can be changed
+	transitioning int
+}
+
+// planInstanceTypeChange decides which instances to stop and which to change
+// the type of. An instance's type is changed over several reconciles: it is
+// stopped, its type is changed once it has stopped, and it is then started.
+//
+// If limit is true, available instances are only stopped while fewer than
+// maxUnavailable of all instances are unavailable, so that each batch must
+// pass status checks before the next is stopped. Instances that are already
+// unavailable are always stopped.
+func planInstanceTypeChange(
+	resized []types.Instance,
+	available map[string]bool,
+	total int,
+	maxUnavailable int,
+	limit bool,
+) instanceTypeChangePlan {
+	plan := instanceTypeChangePlan{}
+	unavailable := total - len(available)
+	for _, inst := range resized {
+		if inst.State == nil {
+			continue
+		}
+		switch inst.State.Name {
+		case types.InstanceStateNameStopped:
+			plan.modify = append(plan.modify, inst)
+		case types.InstanceStateNameRunning:
+			if !limit || !available[aws.ToString(inst.InstanceId)] {
+				plan.stop = append(plan.stop, inst)
+			} else if unavailable < maxUnavailable {
+				plan.stop = append(plan.stop, inst)
+				unavailable++
+			}
+		default:
+			plan.transitioning++
+		}
+	}
+	return plan
+}
+
+// applyInstanceTypeChange stops the instances planned to be stopped, changes
+// the type of those already stopped and, if restart is true, starts them
+// again. Instances are restarted even if changing the type fails so that they
+// are not left stopped.
+func applyInstanceTypeChange(
+	ctx context.Context,
+	ec2Client EC2InstanceClient,
+	plan instanceTypeChangePlan,
+	instanceType string,
+	restart bool,
+) error {
+	if len(plan.stop) > 0 {
+		if _, err := ec2Client.StopInstances(ctx, plan.stop, false); err != nil {
+			return fmt.Errorf("stopping instances: %w", err)
+		}
+	}
+	if len(plan.modify) == 0 {
+		return nil
+	}
+
+	var modifyErr error
+	for _, inst := range plan.modify {
+		if _, err := ec2Client.ModifyInstanceAttribute(ctx, &ec2instanceclient.ModifyInstanceAttributeInput{
+			InstanceID:   aws.ToString(inst.InstanceId),
+			InstanceType: instanceType,
+		}); err != nil {
+			modifyErr = fmt.Errorf("changing instance type of %s: %w", aws.ToString(inst.InstanceId), err)
+			break
+		}
+	}
+
+	if !restart {
+		return modifyErr
+	}
+	if _, err := ec2Client.StartInstances(ctx, plan.modify); err != nil {
+		return errors.Join(modifyErr, fmt.Errorf("starting instances: %w", err))
+	}
+	return modifyErr
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	mockec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/mock_ec2instance_client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// instanceTypeChangeClient records the calls made when changing the
+// instance type and optionally fails ModifyInstanceAttribute
+type instanceTypeChangeClient struct {
+	mockec2instanceclient.MockEC2InstanceClient
+	calls     *[]string
+	modifyErr error
+}
+
+func (c instanceTypeChangeClient) StopInstances(ctx context.Context, instances []types.Instance, hibernate bool) (*ec2.StopInstancesOutput, error) {
+	*c.calls = append(*c.calls, "stop")
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func (c instanceTypeChangeClient) ModifyInstanceAttribute(ctx context.Context, params *ec2instanceclient.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	*c.calls = append(*c.calls, "modify "+params.InstanceID+" "+params.InstanceType)
+	return &ec2.ModifyInstanceAttributeOutput{}, c.modifyErr
+}
+
+func (c instanceTypeChangeClient) StartInstances(ctx context.Context, instances []types.Instance) (*ec2.StartInstancesOutput, error) {
+	*c.calls = append(*c.calls, "start")
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+var _ = Describe("planInstanceTypeChange", func() {
+	makeInstance := func(id string, state types.InstanceStateName) types.Instance {
+		return types.Instance{InstanceId: aws.String(id), State: &types.InstanceState{Name: state}}
+	}
+
+	It("should stop running instances and change the type of stopped ones", func() {
+		resized := []types.Instance{
+			makeInstance("i-1", types.InstanceStateNameRunning),
+			makeInstance("i-2", types.InstanceStateNameStopping),
+			makeInstance("i-3", types.InstanceStateNameStopped),
+		}
+
+		plan := planInstanceTypeChange(resized, nil, 3, 0, false)
+		Expect(plan.stop).Should(HaveLen(1))
+		Expect(*plan.stop[0].InstanceId).Should(Equal("i-1"))
+		Expect(plan.transitioning).Should(Equal(1))
+		Expect(plan.modify).Should(HaveLen(1))
+		Expect(*plan.modify[0].InstanceId).Should(Equal("i-3"))
+	})
+
+	It("should stop available instances in batches of maxUnavailable", func() {
+		resized := []types.Instance{
+			makeInstance("i-1", types.InstanceStateNameRunning),
+			makeInstance("i-2", types.InstanceStateNameRunning),
+			makeInstance("i-3", types.InstanceStateNameRunning),
+		}
+		available := map[string]bool{"i-1": true, "i-2": true, "i-3": true}
+
+		plan := planInstanceTypeChange(resized, available, 3, 1, true)
+		Expect(plan.stop).Should(HaveLen(1))
+	})
+
+	It("should not stop the next batch until the previous one is available", func() {
+		// i-1 has had its type changed and is still initializing
+		resized := []types.Instance{
+			makeInstance("i-2", types.InstanceStateNameRunning),
+			makeInstance("i-3", types.InstanceStateNameRunning),
+		}
+		available := map[string]bool{"i-2": true, "i-3": true}
+
+		plan := planInstanceTypeChange(resized, available, 3, 1, true)
+		Expect(plan.stop).Should(BeEmpty())
+	})
+
+	It("should always stop instances that are already unavailable", func() {
+		resized := []types.Instance{makeInstance("i-1", types.InstanceStateNameRunning)}
+
+		plan := planInstanceTypeChange(resized, map[string]bool{}, 2, 1, true)
+		Expect(plan.stop).Should(HaveLen(1))
+	})
+})
+
+var _ = Describe("applyInstanceTypeChange", func() {
+	var (
+		calls   []string
+		stopped []types.Instance
+	)
+
+	BeforeEach(func() {
+		calls = nil
+		stopped = []types.Instance{{InstanceId: aws.String("i-1")}}
+	})
+
+	It("should stop running instances without waiting for them", func() {
+		ec2Client := instanceTypeChangeClient{calls: &calls}
+		plan := instanceTypeChangePlan{stop: []types.Instance{{InstanceId: aws.String("i-2")}}}
+
+		Expect(applyInstanceTypeChange(context.Background(), ec2Client, plan, "t3.small", true)).Should(Succeed())
+		Expect(calls).Should(Equal([]string{"stop"}))
+	})
+
+	It("should change the type of stopped instances and start them", func() {
+		ec2Client := instanceTypeChangeClient{calls: &calls}
+
+		Expect(applyInstanceTypeChange(context.Background(), ec2Client, instanceTypeChangePlan{modify: stopped}, "t3.small", true)).Should(Succeed())
+		Expect(calls).Should(Equal([]string{"modify i-1 t3.small", "start"}))
+	})
+
+	It("should start the instances again if the type cannot be changed", func() {
+		ec2Client := instanceTypeChangeClient{calls: &calls, modifyErr: errors.New("incompatible instance type")}
+
+		Expect(applyInstanceTypeChange(context.Background(), ec2Client, instanceTypeChangePlan{modify: stopped}, "t3.small", true)).ShouldNot(Succeed())
+		Expect(calls).Should(Equal([]string{"modify i-1 t3.small", "start"}))
+	})
+
+	It("should leave the instances stopped if they are not to be restarted", func() {
+		ec2Client := instanceTypeChangeClient{calls: &calls}
+
+		Expect(applyInstanceTypeChange(context.Background(), ec2Client, instanceTypeChangePlan{modify: stopped}, "t3.small", false)).Should(Succeed())
+		Expect(calls).Should(Equal([]string{"modify i-1 t3.small"}))
+	})
+})
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// inventoryInstanceStates are the states of instances listed in the status.
+// Shutting-down instances are included so that terminations are visible.
+var inventoryInstanceStates = []types.InstanceStateName{
+	types.InstanceStateNamePending,
+	types.InstanceStateNameRunning,
+	types.InstanceStateNameStopping,
+	types.InstanceStateNameStopped,
+	types.InstanceStateNameShuttingDown,
+}
+
+// setInstanceInventory records the instances and the aggregate counts in the
+// status
+func setInstanceInventory(
+	status *ec2instancev1alpha1.EC2InstanceStatus,
+	instances []types.Instance,
+	desiredCount int,
+	desiredState string,
+) {
+	status.Instances = nil
+	status.DesiredInstances = int32(desiredCount)
+	status.ReadyInstances = 0
+	status.PendingInstances = 0
+	status.TerminatingInstances = 0
+	status.Replicas = 0
+
+	readyState := steadyInstanceState(desiredState)
+	for _, inst := range instances {
+		status.Instances = append(status.Instances, instanceStatus(inst))
+		if inst.State == nil {
+			continue
+		}
+		if inst.State.Name == types.InstanceStateNameRunning {
+			status.Replicas++
+		}
+		switch inst.State.Name {
+		case readyState:
+			status.ReadyInstances++
+		case types.InstanceStateNamePending:
+			status.PendingInstances++
+		case types.InstanceStateNameShuttingDown:
+			status.TerminatingInstances++
+		}
+	}
+}
+
+// instanceSelector returns the label selector reported by the scale
+// subresource. It matches the tags identifying the resource's instances, not
+// pod labels, so autoscalers can only use Object or External metrics.
+func instanceSelector(req ctrl.Request) string {
+	return labels.SelectorFromSet(labels.Set{
+		nameTagKey:      req.Name,
+		namespaceTagKey: req.Namespace,
+	}).String()
+}
+
+func instanceStatus(inst types.Instance) ec2instancev1alpha1.InstanceStatus {
+	s := ec2instancev1alpha1.InstanceStatus{
+		InstanceID:       aws.ToString(inst.InstanceId),
+		PrivateIPAddress: aws.ToString(inst.PrivateIpAddress),
+		PublicIPAddress:  aws.ToString(inst.PublicIpAddress),
+		PrivateDNSName:   aws.ToString(inst.PrivateDnsName),
+		ImageID:          aws.ToString(inst.ImageId),
+		InstanceType:     string(inst.InstanceType),
+	}
+	if inst.State != nil {
+		s.State = string(inst.State.Name)
+	}
+	if inst.Placement != nil {
+		s.AvailabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+	if inst.LaunchTime != nil {
+		s.LaunchTime = &metav1.Time{Time: *inst.LaunchTime}
+	}
+	return s
+}
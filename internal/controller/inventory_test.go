@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("setInstanceInventory", func() {
+	launchTime := time.Date(2024, time.July, 10, 12, 0, 0, 0, time.UTC)
+	instances := []types.Instance{
+		{
+			InstanceId:       aws.String("i-1"),
+			State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Placement:        &types.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			PrivateIpAddress: aws.String("10.0.0.1"),
+			PublicIpAddress:  aws.String("203.0.113.1"),
+			PrivateDnsName:   aws.String("ip-10-0-0-1.eu-west-1.compute.internal"),
+			LaunchTime:       &launchTime,
+			ImageId:          aws.String("ami-1"),
+			InstanceType:     types.InstanceTypeT3Micro,
+		},
+		{InstanceId: aws.String("i-2"), State: &types.InstanceState{Name: types.InstanceStateNamePending}},
+		{InstanceId: aws.String("i-3"), State: &types.InstanceState{Name: types.InstanceStateNameShuttingDown}},
+	}
+
+	It("should record each instance", func() {
+		status := v1alpha1.EC2InstanceStatus{}
+		setInstanceInventory(&status, instances, 2, v1alpha1.DesiredStateRunning)
+
+		Expect(status.Instances).Should(HaveLen(3))
+		Expect(status.Instances[0]).Should(Equal(v1alpha1.InstanceStatus{
+			InstanceID:       "i-1",
+			State:            "running",
+			AvailabilityZone: "eu-west-1a",
+			PrivateIPAddress: "10.0.0.1",
+			PublicIPAddress:  "203.0.113.1",
+			PrivateDNSName:   "ip-10-0-0-1.eu-west-1.compute.internal",
+			LaunchTime:       &metav1.Time{Time: launchTime},
+			ImageID:          "ami-1",
+			InstanceType:     "t3.micro",
+		}))
+	})
+
+	It("should count instances by state", func() {
+		status := v1alpha1.EC2InstanceStatus{}
+		setInstanceInventory(&status, instances, 2, v1alpha1.DesiredStateRunning)
+
+		Expect(status.DesiredInstances).Should(Equal(int32(2)))
+		Expect(status.ReadyInstances).Should(Equal(int32(1)))
+		Expect(status.PendingInstances).Should(Equal(int32(1)))
+		Expect(status.TerminatingInstances).Should(Equal(int32(1)))
+	})
+
+	It("should count stopped instances as ready when the desired state is Stopped", func() {
+		status := v1alpha1.EC2InstanceStatus{}
+		stopped := []types.Instance{{InstanceId: aws.String("i-1"), State: &types.InstanceState{Name: types.InstanceStateNameStopped}}}
+		setInstanceInventory(&status, stopped, 1, v1alpha1.DesiredStateStopped)
+
+		Expect(status.ReadyInstances).Should(Equal(int32(1)))
+	})
+
+	It("should count running instances as replicas", func() {
+		status := v1alpha1.EC2InstanceStatus{}
+		setInstanceInventory(&status, instances, 2, v1alpha1.DesiredStateRunning)
+
+		Expect(status.Replicas).Should(Equal(int32(1)))
+	})
+})
+
+var _ = Describe("instanceSelector", func() {
+	It("should select instances by their name and namespace tags", func() {
+		req := ctrl.Request{NamespacedName: k8stypes.NamespacedName{Name: "web", Namespace: "team-a"}}
+		Expect(instanceSelector(req)).Should(Equal("kraken-name=web,kraken-namespace=team-a"))
+	})
+})
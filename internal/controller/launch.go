@@ -0,0 +1,254 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+)
+
+const (
+	// launchPollInterval is how often launched instances are checked while
+	// waiting for them to reach the running state
+	launchPollInterval = 10 * time.Second
+)
+
+// launchTimeout returns the resource's launch timeout, falling back to the
+// manager default
+func (r *EC2InstanceReconciler) launchTimeout(spec ec2instancev1alpha1.EC2InstanceSpec) time.Duration {
+	if spec.LaunchTimeout != nil {
+		return spec.LaunchTimeout.Duration
+	}
+	return r.LaunchTimeout
+}
+
+// launchClientToken derives the idempotency token for a launch from the
+// resource's UID and generation and the launch batch
+func launchClientToken(uid string, generation, batch int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d", uid, generation, batch)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// reserveLaunch returns the client token and subnet placements for the next
+// launch. The token and placements left in the status by a launch that was
+// never recorded are reused, so that retrying it sends the same requests and
+// returns the instances it already launched. Otherwise the given placements
+// are used with a new token. Both are persisted before they are used.
+func (r *EC2InstanceReconciler) reserveLaunch(
+	ctx context.Context,
+	ec2Instance *ec2instancev1alpha1.EC2Instance,
+	placements []subnetPlacement,
+) (string, []subnetPlacement, error) {
+	status := &ec2Instance.Status
+	if status.LaunchClientToken != "" && len(status.LaunchPlacements) > 0 {
+		return status.LaunchClientToken, fromLaunchPlacements(status.LaunchPlacements), nil
+	}
+	if status.LaunchClientToken == "" {
+		status.LaunchBatch++
+		status.LaunchClientToken = launchClientToken(
+			string(ec2Instance.UID),
+			ec2Instance.Generation,
+			status.LaunchBatch,
+		)
+	}
+	status.LaunchPlacements = toLaunchPlacements(placements)
+	if err := r.Status().Update(ctx, ec2Instance); err != nil {
+		return "", nil, err
+	}
+	return status.LaunchClientToken, placements, nil
+}
+
+func toLaunchPlacements(placements []subnetPlacement) []ec2instancev1alpha1.LaunchPlacement {
+	launchPlacements := make([]ec2instancev1alpha1.LaunchPlacement, len(placements))
+	for i, placement := range placements {
+		launchPlacements[i] = ec2instancev1alpha1.LaunchPlacement{
+			SubnetID: placement.subnetID,
+			MaxCount: int32(placement.maxCount),
+			MinCount: int32(placement.minCount),
+		}
+	}
+	return launchPlacements
+}
+
+func fromLaunchPlacements(launchPlacements []ec2instancev1alpha1.LaunchPlacement) []subnetPlacement {
+	placements := make([]subnetPlacement, len(launchPlacements))
+	for i, launchPlacement := range launchPlacements {
+		placements[i] = subnetPlacement{
+			subnetID: launchPlacement.SubnetID,
+			maxCount: int(launchPlacement.MaxCount),
+			minCount: int(launchPlacement.MinCount),
+		}
+	}
+	return placements
+}
+
+// recordLaunch tracks the launched instances in the status so that they are
+// checked in later reconciles, and retires the launch's client token and
+// placements
+func recordLaunch(status *ec2instancev1alpha1.EC2InstanceStatus, launchedIDs []string) {
+	if len(launchedIDs) > 0 {
+		status.PendingLaunch = &ec2instancev1alpha1.PendingLaunch{
+			InstanceIDs: launchedIDs,
+			LaunchTime:  metav1.Now(),
+		}
+	}
+	status.LaunchClientToken = ""
+	status.LaunchPlacements = nil
+}
+
+// recordFailedLaunch records the instances launched before a launch failed.
+// Otherwise the client token is kept so that retrying returns any instances
+// the failed request did launch, unless the token can never succeed.
+func recordFailedLaunch(status *ec2instancev1alpha1.EC2InstanceStatus, launchedIDs []string, err error) {
+	if len(launchedIDs) > 0 || ec2instanceclient.IsIdempotentParameterMismatch(err) {
+		recordLaunch(status, launchedIDs)
+	}
+}
+
+type launchProgress struct {
+	// pending are launched instances that have not yet reached the running
+	// state
+	pending []types.Instance
+
+	// notFoundIDs are the IDs of launched instances that are not yet visible
+	// to the EC2 API. They are treated as pending.
+	notFoundIDs []string
+
+	// terminated are launched instances that shut down before reaching the
+	// running state
+	terminated []types.Instance
+}
+
+// checkLaunchProgress sorts the launched instances into those still pending,
+// those not yet found and those that were terminated. Launched instances in
+// any other state have reached the running state.
+func checkLaunchProgress(launchedIDs []string, instances []types.Instance) launchProgress {
+	byID := make(map[string]types.Instance, len(instances))
+	for _, inst := range instances {
+		byID[aws.ToString(inst.InstanceId)] = inst
+	}
+
+	progress := launchProgress{}
+	for _, id := range launchedIDs {
+		inst, ok := byID[id]
+		if !ok {
+			progress.notFoundIDs = append(progress.notFoundIDs, id)
+			continue
+		}
+		if inst.State == nil {
+			continue
+		}
+		switch inst.State.Name {
+		case types.InstanceStateNamePending:
+			progress.pending = append(progress.pending, inst)
+		case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+			progress.terminated = append(progress.terminated, inst)
+		}
+	}
+	return progress
+}
+
+// checkPendingLaunch checks whether the instances of the pending launch have
+// reached the running state. It returns true while instances are still
+// pending or not yet found within the launch timeout. Otherwise the launch is
+// cleared from the status, instances that did not reach the running state are
+// recorded as failed, and those still pending are terminated and returned so
+// that they can be replaced.
+func (r *EC2InstanceReconciler) checkPendingLaunch(
+	ctx context.Context,
+	ec2Client EC2InstanceClient,
+	ec2Instance *ec2instancev1alpha1.EC2Instance,
+) (bool, []types.Instance, error) {
+	log := log.FromContext(ctx)
+	launch := ec2Instance.Status.PendingLaunch
+
+	// Describe the launched instances by ID, as they may not yet be tagged
+	// or visible to filtered requests
+	instances, err := ec2Client.GetInstances(ctx, ec2instanceclient.FilterOptions{
+		InstanceIDs: launch.InstanceIDs,
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("retrieving launched instances: %w", err)
+	}
+
+	progress := checkLaunchProgress(launch.InstanceIDs, instances)
+	launchTimeout := r.launchTimeout(ec2Instance.Spec)
+	timedOut := time.Since(launch.LaunchTime.Time) > launchTimeout
+	if len(progress.pending)+len(progress.notFoundIDs) > 0 && !timedOut {
+		return true, nil, nil
+	}
+
+	// Spot instances interrupted before reaching the running state are
+	// replaced rather than treated as a failure
+	var failed []ec2instancev1alpha1.FailedInstance
+	interruptedCount := 0
+	for _, inst := range progress.terminated {
+		if isSpotInterrupted(inst) {
+			interruptedCount++
+			continue
+		}
+		failed = append(failed, ec2instancev1alpha1.FailedInstance{
+			InstanceID: aws.ToString(inst.InstanceId),
+			Reason:     "Terminated before reaching the running state",
+		})
+	}
+	if interruptedCount > 0 {
+		log.Info("Spot instances were interrupted; they will be replaced", "instanceCount", interruptedCount)
+		r.Recorder.Event(ec2Instance, "Normal", "SpotInterrupted",
+			fmt.Sprintf("%d spot instance(s) were interrupted and will be replaced", interruptedCount),
+		)
+	}
+
+	if len(progress.pending) > 0 {
+		log.Info("Terminating EC2 instances that did not reach the running state", "instanceCount", len(progress.pending))
+		if _, err := ec2Client.TerminateInstances(ctx, progress.pending); err != nil {
+			return false, nil, fmt.Errorf("terminating instances that did not reach the running state: %w", err)
+		}
+		for _, inst := range progress.pending {
+			failed = append(failed, ec2instancev1alpha1.FailedInstance{
+				InstanceID: aws.ToString(inst.InstanceId),
+				Reason:     fmt.Sprintf("Did not reach the running state within %s", launchTimeout),
+			})
+		}
+	}
+	for _, id := range progress.notFoundIDs {
+		failed = append(failed, ec2instancev1alpha1.FailedInstance{
+			InstanceID: id,
+			Reason:     fmt.Sprintf("Not found within %s", launchTimeout),
+		})
+	}
+
+	if len(failed) > 0 {
+		r.Recorder.Event(ec2Instance, "Warning", "LaunchFailed",
+			fmt.Sprintf("%d of %d launched instance(s) did not reach the running state", len(failed), len(launch.InstanceIDs)),
+		)
+	}
+	ec2Instance.Status.FailedInstances = failed
+	ec2Instance.Status.PendingLaunch = nil
+	return false, progress.pending, nil
+}
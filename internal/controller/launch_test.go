@@ -0,0 +1,454 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	mockec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/mock_ec2instance_client"
+	"github.com/kraken-iac/common/types/option"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	krakenv1alpha1 "github.com/kraken-iac/kraken/api/core/v1alpha1"
+)
+
+// terminateRecordingClient records the IDs of terminated instances
+type terminateRecordingClient struct {
+	mockec2instanceclient.MockEC2InstanceClient
+	terminated *[]string
+}
+
+func (c terminateRecordingClient) TerminateInstances(ctx context.Context, instances []types.Instance) (*ec2.TerminateInstancesOutput, error) {
+	for _, inst := range instances {
+		*c.terminated = append(*c.terminated, aws.ToString(inst.InstanceId))
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+// clientTokenRecordingClient records the client token of each launch
+type clientTokenRecordingClient struct {
+	mockec2instanceclient.MockEC2InstanceClient
+	clientTokens *[]string
+}
+
+func (c clientTokenRecordingClient) RunInstances(ctx context.Context, params *ec2instanceclient.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+	*c.clientTokens = append(*c.clientTokens, params.ClientToken)
+	return &ec2.RunInstancesOutput{}, nil
+}
+
+// fakeEC2Client keeps the instances it launches so that they can be moved
+// through their lifecycle between reconciles
+type fakeEC2Client struct {
+	mockec2instanceclient.MockEC2InstanceClient
+	instances []types.Instance
+}
+
+func (c *fakeEC2Client) RunInstances(ctx context.Context, params *ec2instanceclient.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+	var launched []types.Instance
+	for i := 0; i < params.MaxCount; i++ {
+		inst := types.Instance{
+			InstanceId:   aws.String(fmt.Sprintf("i-%d", len(c.instances)+1)),
+			ImageId:      aws.String(params.ImageID),
+			InstanceType: types.InstanceType(params.InstanceType),
+			State:        &types.InstanceState{Name: types.InstanceStateNamePending},
+		}
+		for k, v := range params.Tags {
+			inst.Tags = append(inst.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		c.instances = append(c.instances, inst)
+		launched = append(launched, inst)
+	}
+	return &ec2.RunInstancesOutput{Instances: launched}, nil
+}
+
+func (c *fakeEC2Client) GetInstances(ctx context.Context, filterOptions ec2instanceclient.FilterOptions) ([]types.Instance, error) {
+	ids := make(map[string]bool, len(filterOptions.InstanceIDs))
+	for _, id := range filterOptions.InstanceIDs {
+		ids[id] = true
+	}
+	instances := c.instances
+	if len(filterOptions.MatchStates) > 0 {
+		instances = filterInstancesByState(instances, filterOptions.MatchStates...)
+	}
+	var matched []types.Instance
+	for _, inst := range instances {
+		if len(ids) == 0 || ids[*inst.InstanceId] {
+			matched = append(matched, inst)
+		}
+	}
+	return matched, nil
+}
+
+// setState moves all instances to the given state
+func (c *fakeEC2Client) setState(state types.InstanceStateName) {
+	for i := range c.instances {
+		c.instances[i].State = &types.InstanceState{Name: state}
+	}
+}
+
+// subnetFailingClient fails launches into one subnet
+type subnetFailingClient struct {
+	fakeEC2Client
+	failingSubnet string
+}
+
+func (c *subnetFailingClient) RunInstances(ctx context.Context, params *ec2instanceclient.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+	if params.SubnetID == c.failingSubnet {
+		return nil, errors.New("insufficient capacity")
+	}
+	return c.fakeEC2Client.RunInstances(ctx, params)
+}
+
+var _ = Describe("launchClientToken", func() {
+	It("should derive the same token for the same launch", func() {
+		Expect(launchClientToken("uid", 1, 1)).Should(Equal(launchClientToken("uid", 1, 1)))
+		Expect(launchClientToken("uid", 1, 1)).Should(HaveLen(32))
+	})
+
+	It("should derive different tokens for different generations and batches", func() {
+		Expect(launchClientToken("uid", 1, 1)).ShouldNot(Equal(launchClientToken("uid", 2, 1)))
+		Expect(launchClientToken("uid", 1, 1)).ShouldNot(Equal(launchClientToken("uid", 1, 2)))
+	})
+})
+
+var _ = Describe("reserveLaunch", func() {
+	It("should reuse the token and placements of an unrecorded launch", func() {
+		ec2Instance := &v1alpha1.EC2Instance{
+			Status: v1alpha1.EC2InstanceStatus{
+				LaunchBatch:       3,
+				LaunchClientToken: "token",
+				LaunchPlacements: []v1alpha1.LaunchPlacement{
+					{SubnetID: "subnet-a", MaxCount: 2, MinCount: 1},
+				},
+			},
+		}
+
+		token, placements, err := (&EC2InstanceReconciler{}).reserveLaunch(
+			context.Background(), ec2Instance, []subnetPlacement{{subnetID: "subnet-b", maxCount: 1, minCount: 1}},
+		)
+		Expect(err).Should(BeNil())
+		Expect(token).Should(Equal("token"))
+		Expect(placements).Should(Equal([]subnetPlacement{{subnetID: "subnet-a", maxCount: 2, minCount: 1}}))
+		Expect(ec2Instance.Status.LaunchBatch).Should(Equal(int64(3)))
+	})
+
+	It("should persist a new token with the placements", func() {
+		ec2Instance := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-ec2instance", Namespace: "default", UID: "uid"},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(newTestScheme()).
+			WithObjects(ec2Instance).
+			WithStatusSubresource(ec2Instance).
+			Build()
+
+		token, placements, err := (&EC2InstanceReconciler{Client: fakeClient}).reserveLaunch(
+			context.Background(), ec2Instance, []subnetPlacement{{subnetID: "subnet-b", maxCount: 1, minCount: 1}},
+		)
+		Expect(err).Should(BeNil())
+		Expect(token).Should(Equal(launchClientToken("uid", 0, 1)))
+		Expect(placements).Should(Equal([]subnetPlacement{{subnetID: "subnet-b", maxCount: 1, minCount: 1}}))
+
+		persisted := &v1alpha1.EC2Instance{}
+		Expect(fakeClient.Get(context.Background(), client.ObjectKeyFromObject(ec2Instance), persisted)).To(Succeed())
+		Expect(persisted.Status.LaunchClientToken).Should(Equal(token))
+		Expect(persisted.Status.LaunchPlacements).Should(Equal([]v1alpha1.LaunchPlacement{
+			{SubnetID: "subnet-b", MaxCount: 1, MinCount: 1},
+		}))
+	})
+})
+
+var _ = Describe("recordFailedLaunch", func() {
+	var status *v1alpha1.EC2InstanceStatus
+
+	BeforeEach(func() {
+		status = &v1alpha1.EC2InstanceStatus{
+			LaunchClientToken: "token",
+			LaunchPlacements:  []v1alpha1.LaunchPlacement{{MaxCount: 1, MinCount: 1}},
+		}
+	})
+
+	It("should keep the client token so that the launch can be retried", func() {
+		recordFailedLaunch(status, nil, errors.New("request timed out"))
+		Expect(status.LaunchClientToken).Should(Equal("token"))
+		Expect(status.LaunchPlacements).Should(HaveLen(1))
+		Expect(status.PendingLaunch).Should(BeNil())
+	})
+
+	It("should retire a client token that was used with different parameters", func() {
+		recordFailedLaunch(status, nil, &smithy.GenericAPIError{Code: "IdempotentParameterMismatch"})
+		Expect(status.LaunchClientToken).Should(BeEmpty())
+		Expect(status.LaunchPlacements).Should(BeEmpty())
+		Expect(status.PendingLaunch).Should(BeNil())
+	})
+
+	It("should track instances launched before the failure", func() {
+		recordFailedLaunch(status, []string{"i-1"}, errors.New("insufficient capacity"))
+		Expect(status.LaunchClientToken).Should(BeEmpty())
+		Expect(status.PendingLaunch).ShouldNot(BeNil())
+		Expect(status.PendingLaunch.InstanceIDs).Should(Equal([]string{"i-1"}))
+	})
+})
+
+var _ = Describe("launchInstances", func() {
+	It("should launch into each subnet with its own client token", func() {
+		var clientTokens []string
+		placements := []subnetPlacement{
+			{subnetID: "subnet-a", maxCount: 1, minCount: 1},
+			{subnetID: "subnet-b", maxCount: 1, minCount: 1},
+		}
+
+		_, err := launchInstances(
+			context.Background(),
+			clientTokenRecordingClient{clientTokens: &clientTokens},
+			&ec2InstanceApplicableValues{}, placements, nil, "token",
+		)
+		Expect(err).Should(BeNil())
+		Expect(clientTokens).Should(Equal([]string{"token-0", "token-1"}))
+	})
+
+	It("should return the instances launched before a subnet fails", func() {
+		placements := []subnetPlacement{
+			{subnetID: "subnet-a", maxCount: 1, minCount: 1},
+			{subnetID: "subnet-b", maxCount: 1, minCount: 1},
+		}
+
+		launchedIDs, err := launchInstances(
+			context.Background(),
+			&subnetFailingClient{failingSubnet: "subnet-b"},
+			&ec2InstanceApplicableValues{}, placements, nil, "token",
+		)
+		Expect(err).ShouldNot(BeNil())
+		Expect(launchedIDs).Should(Equal([]string{"i-1"}))
+	})
+})
+
+var _ = Describe("checkLaunchProgress", func() {
+	It("should sort launched instances by progress", func() {
+		instances := []types.Instance{
+			{InstanceId: aws.String("i-1"), State: &types.InstanceState{Name: types.InstanceStateNameRunning}},
+			{InstanceId: aws.String("i-2"), State: &types.InstanceState{Name: types.InstanceStateNamePending}},
+			{InstanceId: aws.String("i-3"), State: &types.InstanceState{Name: types.InstanceStateNameTerminated}},
+			{InstanceId: aws.String("i-5"), State: &types.InstanceState{Name: types.InstanceStateNamePending}},
+		}
+
+		progress := checkLaunchProgress([]string{"i-1", "i-2", "i-3", "i-4"}, instances)
+		Expect(progress.pending).Should(HaveLen(1))
+		Expect(*progress.pending[0].InstanceId).Should(Equal("i-2"))
+		Expect(progress.terminated).Should(HaveLen(1))
+		Expect(*progress.terminated[0].InstanceId).Should(Equal("i-3"))
+		Expect(progress.notFoundIDs).Should(Equal([]string{"i-4"}))
+	})
+})
+
+var _ = Describe("checkPendingLaunch", func() {
+	var (
+		terminated  []string
+		r           *EC2InstanceReconciler
+		ec2Instance *v1alpha1.EC2Instance
+		instances   []types.Instance
+	)
+
+	BeforeEach(func() {
+		terminated = nil
+		r = &EC2InstanceReconciler{Recorder: record.NewFakeRecorder(10), LaunchTimeout: time.Minute}
+		ec2Instance = &v1alpha1.EC2Instance{
+			Status: v1alpha1.EC2InstanceStatus{
+				PendingLaunch: &v1alpha1.PendingLaunch{
+					InstanceIDs: []string{"i-1", "i-2"},
+					LaunchTime:  metav1.Now(),
+				},
+			},
+		}
+		instances = []types.Instance{
+			{InstanceId: aws.String("i-1"), State: &types.InstanceState{Name: types.InstanceStateNameRunning}},
+			{InstanceId: aws.String("i-2"), State: &types.InstanceState{Name: types.InstanceStateNamePending}},
+		}
+	})
+
+	check := func() (bool, []types.Instance, error) {
+		return r.checkPendingLaunch(
+			context.Background(),
+			listingClient{
+				terminateRecordingClient: terminateRecordingClient{terminated: &terminated},
+				instances:                instances,
+			},
+			ec2Instance,
+		)
+	}
+
+	It("should keep waiting while instances are pending within the timeout", func() {
+		waiting, timedOut, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeTrue())
+		Expect(timedOut).Should(BeEmpty())
+		Expect(ec2Instance.Status.PendingLaunch).ShouldNot(BeNil())
+	})
+
+	It("should keep waiting for instances not yet found within the timeout", func() {
+		instances = instances[:1]
+
+		waiting, _, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeTrue())
+		Expect(ec2Instance.Status.FailedInstances).Should(BeEmpty())
+	})
+
+	It("should terminate and mark as failed instances still pending after the timeout", func() {
+		ec2Instance.Status.PendingLaunch.LaunchTime = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+
+		waiting, timedOut, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeFalse())
+		Expect(timedOut).Should(HaveLen(1))
+		Expect(terminated).Should(Equal([]string{"i-2"}))
+		Expect(ec2Instance.Status.PendingLaunch).Should(BeNil())
+		Expect(ec2Instance.Status.FailedInstances).Should(HaveLen(1))
+		Expect(ec2Instance.Status.FailedInstances[0].InstanceID).Should(Equal("i-2"))
+	})
+
+	It("should mark as failed instances not found after the timeout", func() {
+		instances = instances[:1]
+		ec2Instance.Status.PendingLaunch.LaunchTime = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+
+		waiting, _, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeFalse())
+		Expect(terminated).Should(BeEmpty())
+		Expect(ec2Instance.Status.FailedInstances).Should(Equal([]v1alpha1.FailedInstance{{
+			InstanceID: "i-2",
+			Reason:     "Not found within 1m0s",
+		}}))
+	})
+
+	It("should mark as failed instances terminated before reaching the running state", func() {
+		instances[1].State.Name = types.InstanceStateNameTerminated
+
+		waiting, _, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeFalse())
+		Expect(terminated).Should(BeEmpty())
+		Expect(ec2Instance.Status.FailedInstances).Should(Equal([]v1alpha1.FailedInstance{{
+			InstanceID: "i-2",
+			Reason:     "Terminated before reaching the running state",
+		}}))
+	})
+
+	It("should replace interrupted spot instances without marking them as failed", func() {
+		instances[1].State.Name = types.InstanceStateNameTerminated
+		instances[1].InstanceLifecycle = types.InstanceLifecycleTypeSpot
+		instances[1].StateReason = &types.StateReason{Code: aws.String(spotTerminationStateReason)}
+
+		waiting, _, err := check()
+		Expect(err).Should(BeNil())
+		Expect(waiting).Should(BeFalse())
+		Expect(ec2Instance.Status.FailedInstances).Should(BeEmpty())
+		Expect(ec2Instance.Status.PendingLaunch).Should(BeNil())
+	})
+})
+
+// newTestScheme returns a scheme with the types used by the reconciler
+func newTestScheme() *runtime.Scheme {
+	testScheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(v1alpha1.AddToScheme(testScheme)).To(Succeed())
+	Expect(krakenv1alpha1.AddToScheme(testScheme)).To(Succeed())
+	return testScheme
+}
+
+var _ = Describe("Reconcile launch", func() {
+	It("should track launched instances across reconciles until they are running", func() {
+		ctx := context.Background()
+		testScheme := newTestScheme()
+
+		imageID, instanceType, count := "ami-1234abcd", "t2.nano", 2
+		ec2Instance := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-ec2instance", Namespace: "default"},
+			Spec: v1alpha1.EC2InstanceSpec{
+				ImageID:      option.String{Value: &imageID},
+				InstanceType: option.String{Value: &instanceType},
+				MaxCount:     option.Int{Value: &count},
+				MinCount:     option.Int{Value: &count},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(ec2Instance).
+			WithStatusSubresource(ec2Instance).
+			Build()
+		ec2Client := &fakeEC2Client{}
+		r := &EC2InstanceReconciler{
+			Client:        fakeClient,
+			Scheme:        testScheme,
+			Recorder:      record.NewFakeRecorder(10),
+			APIReader:     fakeClient,
+			LaunchTimeout: time.Minute,
+			EC2InstanceClients: NewEC2InstanceClientPool(
+				"us-east-1",
+				func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+					return ec2Client, nil
+				},
+			),
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ec2Instance)}
+		reconcile := func() ctrl.Result {
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(fakeClient.Get(ctx, req.NamespacedName, ec2Instance)).To(Succeed())
+			return result
+		}
+
+		By("setting initial conditions and adding the finalizer")
+		reconcile()
+		reconcile()
+
+		By("launching instances without waiting for them")
+		Expect(reconcile().RequeueAfter).Should(Equal(launchPollInterval))
+		Expect(ec2Client.instances).Should(HaveLen(2))
+		Expect(ec2Instance.Status.PendingLaunch).ShouldNot(BeNil())
+		Expect(ec2Instance.Status.PendingLaunch.InstanceIDs).Should(Equal([]string{"i-1", "i-2"}))
+		Expect(ec2Instance.Status.LaunchClientToken).Should(BeEmpty())
+		Expect(ec2Instance.Status.LaunchPlacements).Should(BeEmpty())
+
+		By("waiting while the instances are pending")
+		Expect(reconcile().RequeueAfter).Should(Equal(launchPollInterval))
+		Expect(ec2Client.instances).Should(HaveLen(2))
+		Expect(ec2Instance.Status.PendingLaunch).ShouldNot(BeNil())
+		Expect(meta.IsStatusConditionTrue(ec2Instance.Status.Conditions, conditionTypeReady)).Should(BeFalse())
+		Expect(meta.IsStatusConditionFalse(ec2Instance.Status.Conditions, conditionTypeInstancesLaunched)).Should(BeTrue())
+
+		By("becoming ready once the instances are running")
+		ec2Client.setState(types.InstanceStateNameRunning)
+		reconcile()
+		Expect(ec2Client.instances).Should(HaveLen(2))
+		Expect(ec2Instance.Status.PendingLaunch).Should(BeNil())
+		Expect(ec2Instance.Status.FailedInstances).Should(BeEmpty())
+		Expect(meta.IsStatusConditionTrue(ec2Instance.Status.Conditions, conditionTypeReady)).Should(BeTrue())
+		for _, conditionType := range []string{
+			conditionTypeDependenciesResolved,
+			conditionTypeInstancesLaunched,
+			conditionTypeInstancesHealthy,
+			conditionTypeStateDeclared,
+			conditionTypeSynced,
+		} {
+			Expect(meta.IsStatusConditionTrue(ec2Instance.Status.Conditions, conditionType)).Should(BeTrue(), conditionType)
+		}
+		Expect(ec2Instance.Status.ObservedGeneration).Should(Equal(ec2Instance.Generation))
+	})
+})
@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// managedInstanceStates are the states of instances that belong to the set.
+// Terminated and shutting-down instances are no longer counted.
+var managedInstanceStates = []types.InstanceStateName{
+	types.InstanceStateNamePending,
+	types.InstanceStateNameRunning,
+	types.InstanceStateNameStopping,
+	types.InstanceStateNameStopped,
+}
+
+func desiredPowerState(spec ec2instancev1alpha1.EC2InstanceSpec) string {
+	if spec.DesiredState == "" {
+		return ec2instancev1alpha1.DesiredStateRunning
+	}
+	return spec.DesiredState
+}
+
+// steadyInstanceState returns the state instances settle in for the desired
+// power state. Hibernated instances are reported as stopped by EC2.
+func steadyInstanceState(desiredState string) types.InstanceStateName {
+	if desiredState == ec2instancev1alpha1.DesiredStateRunning {
+		return types.InstanceStateNameRunning
+	}
+	return types.InstanceStateNameStopped
+}
+
+type powerStatePlan struct {
+	start []types.Instance
+	stop  []types.Instance
+
+	// transitioning is the number of instances that must finish changing
+	// state before they can be started or stopped
+	transitioning int
+}
+
+func (p powerStatePlan) converged() bool {
+	return len(p.start) == 0 && len(p.stop) == 0 && p.transitioning == 0
+}
+
+// planPowerStateChanges decides which instances to start or stop to reach the
+// desired power state. Pending instances are left to reach the running state
+// by themselves. Spot instances stopped by an interruption are restarted by
+// EC2 and cannot be started manually.
+func planPowerStateChanges(instances []types.Instance, desiredState string) powerStatePlan {
+	plan := powerStatePlan{}
+	for _, inst := range instances {
+		if inst.State == nil {
+			continue
+		}
+		switch desiredState {
+		case ec2instancev1alpha1.DesiredStateRunning:
+			switch inst.State.Name {
+			case types.InstanceStateNameStopped:
+				if !isSpotInterrupted(inst) {
+					plan.start = append(plan.start, inst)
+				}
+			case types.InstanceStateNameStopping:
+				plan.transitioning++
+			}
+		default:
+			switch inst.State.Name {
+			case types.InstanceStateNameRunning:
+				plan.stop = append(plan.stop, inst)
+			case types.InstanceStateNamePending, types.InstanceStateNameStopping:
+				plan.transitioning++
+			}
+		}
+	}
+	return plan
+}
+
+func applyPowerStateChanges(
+	ctx context.Context,
+	ec2Client EC2InstanceClient,
+	plan powerStatePlan,
+	desiredState string,
+) error {
+	if len(plan.start) > 0 {
+		if _, err := ec2Client.StartInstances(ctx, plan.start); err != nil {
+			return fmt.Errorf("starting instances: %w", err)
+		}
+	}
+	if len(plan.stop) > 0 {
+		hibernate := desiredState == ec2instancev1alpha1.DesiredStateHibernated
+		if _, err := ec2Client.StopInstances(ctx, plan.stop, hibernate); err != nil {
+			return fmt.Errorf("stopping instances: %w", err)
+		}
+	}
+	return nil
+}
+
+func filterInstancesByState(instances []types.Instance, states ...types.InstanceStateName) []types.Instance {
+	filtered := make([]types.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if inst.State == nil {
+			continue
+		}
+		for _, state := range states {
+			if inst.State.Name == state {
+				filtered = append(filtered, inst)
+				break
+			}
+		}
+	}
+	return filtered
+}
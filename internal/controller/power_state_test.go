@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("planPowerStateChanges", func() {
+	makeInstance := func(id string, state types.InstanceStateName) types.Instance {
+		return types.Instance{
+			InstanceId: aws.String(id),
+			State:      &types.InstanceState{Name: state},
+		}
+	}
+
+	It("should start stopped instances when they should be running", func() {
+		instances := []types.Instance{
+			makeInstance("i-1", types.InstanceStateNameRunning),
+			makeInstance("i-2", types.InstanceStateNameStopped),
+			makeInstance("i-3", types.InstanceStateNamePending),
+		}
+
+		plan := planPowerStateChanges(instances, v1alpha1.DesiredStateRunning)
+		Expect(plan.start).Should(HaveLen(1))
+		Expect(*plan.start[0].InstanceId).Should(Equal("i-2"))
+		Expect(plan.stop).Should(BeEmpty())
+		Expect(plan.converged()).Should(BeFalse())
+	})
+
+	It("should wait for stopping instances before starting them", func() {
+		plan := planPowerStateChanges(
+			[]types.Instance{makeInstance("i-1", types.InstanceStateNameStopping)},
+			v1alpha1.DesiredStateRunning,
+		)
+		Expect(plan.start).Should(BeEmpty())
+		Expect(plan.transitioning).Should(Equal(1))
+	})
+
+	It("should not start spot instances stopped by an interruption", func() {
+		inst := makeInstance("i-1", types.InstanceStateNameStopped)
+		inst.InstanceLifecycle = types.InstanceLifecycleTypeSpot
+		inst.StateReason = &types.StateReason{Code: aws.String(spotShutdownStateReason)}
+
+		plan := planPowerStateChanges([]types.Instance{inst}, v1alpha1.DesiredStateRunning)
+		Expect(plan.converged()).Should(BeTrue())
+	})
+
+	It("should stop running instances and wait for pending ones when they should be stopped", func() {
+		instances := []types.Instance{
+			makeInstance("i-1", types.InstanceStateNameRunning),
+			makeInstance("i-2", types.InstanceStateNameStopped),
+			makeInstance("i-3", types.InstanceStateNamePending),
+		}
+
+		plan := planPowerStateChanges(instances, v1alpha1.DesiredStateHibernated)
+		Expect(plan.stop).Should(HaveLen(1))
+		Expect(*plan.stop[0].InstanceId).Should(Equal("i-1"))
+		Expect(plan.transitioning).Should(Equal(1))
+	})
+
+	It("should be converged when every instance is stopped", func() {
+		plan := planPowerStateChanges(
+			[]types.Instance{makeInstance("i-1", types.InstanceStateNameStopped)},
+			v1alpha1.DesiredStateStopped,
+		)
+		Expect(plan.converged()).Should(BeTrue())
+	})
+})
@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+)
+
+const (
+	accessKeyIDSecretKey     string = "AWS_ACCESS_KEY_ID"
+	secretAccessKeySecretKey string = "AWS_SECRET_ACCESS_KEY"
+	sessionTokenSecretKey    string = "AWS_SESSION_TOKEN"
+
+	// credentialsRetryInterval is how often a deleted EC2Instance retries
+	// terminating its instances while no credentials are available
+	credentialsRetryInterval = time.Minute
+)
+
+// getClientConfig resolves the region and any ProviderConfig credentials
+// used to create the EC2 client for an EC2Instance
+func (r *EC2InstanceReconciler) getClientConfig(
+	ctx context.Context, ec2Instance *ec2instancev1alpha1.EC2Instance,
+) (*ClientConfig, error) {
+	clientConfig := ClientConfig{Region: ec2Instance.Spec.Region}
+	if ec2Instance.Spec.ProviderConfigRef == nil {
+		return &clientConfig, nil
+	}
+
+	providerConfigKey := client.ObjectKey{
+		Name:      ec2Instance.Spec.ProviderConfigRef.Name,
+		Namespace: ec2Instance.Namespace,
+	}
+	providerConfig := &ec2instancev1alpha1.ProviderConfig{}
+	if err := r.Client.Get(ctx, providerConfigKey, providerConfig); err != nil {
+		return nil, fmt.Errorf("could not get ProviderConfig \"%s\": %w", providerConfigKey.Name, err)
+	}
+	clientConfig.ProviderConfig = providerConfigKey.String()
+	clientConfig.Version = fmt.Sprintf("%d", providerConfig.Generation)
+
+	if secretRef := providerConfig.Spec.CredentialsSecretRef; secretRef != nil {
+		secret := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, client.ObjectKey{Name: secretRef.Name, Namespace: ec2Instance.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("could not get credentials Secret \"%s\": %w", secretRef.Name, err)
+		}
+		accessKeyID, secretAccessKey := secret.Data[accessKeyIDSecretKey], secret.Data[secretAccessKeySecretKey]
+		if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+			return nil, fmt.Errorf(
+				"credentials Secret \"%s\" must contain %s and %s",
+				secretRef.Name, accessKeyIDSecretKey, secretAccessKeySecretKey,
+			)
+		}
+		clientConfig.Options = append(clientConfig.Options, ec2instanceclient.WithStaticCredentials(
+			string(accessKeyID),
+			string(secretAccessKey),
+			string(secret.Data[sessionTokenSecretKey]),
+		))
+		// Rotated credentials must result in a new client
+		clientConfig.Version += "/" + secret.ResourceVersion
+	}
+
+	if assumeRole := providerConfig.Spec.AssumeRole; assumeRole != nil {
+		clientConfig.Options = append(clientConfig.Options, ec2instanceclient.WithAssumeRole(
+			assumeRole.RoleARN,
+			assumeRole.ExternalID,
+			assumeRole.SessionName,
+		))
+	}
+
+	return &clientConfig, nil
+}
+
+// ec2InstancesForProviderConfig returns requests for the EC2Instances that
+// reference a ProviderConfig
+func (r *EC2InstanceReconciler) ec2InstancesForProviderConfig(ctx context.Context, obj client.Object) []ctrl.Request {
+	return r.ec2InstancesUsingProviderConfigs(ctx, obj.GetNamespace(), map[string]bool{obj.GetName(): true})
+}
+
+// ec2InstancesForSecret returns requests for the EC2Instances whose
+// ProviderConfig takes its credentials from a Secret
+func (r *EC2InstanceReconciler) ec2InstancesForSecret(ctx context.Context, obj client.Object) []ctrl.Request {
+	providerConfigs := &ec2instancev1alpha1.ProviderConfigList{}
+	if err := r.Client.List(ctx, providerConfigs, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ProviderConfigs")
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, providerConfig := range providerConfigs.Items {
+		if secretRef := providerConfig.Spec.CredentialsSecretRef; secretRef != nil && secretRef.Name == obj.GetName() {
+			names[providerConfig.Name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return r.ec2InstancesUsingProviderConfigs(ctx, obj.GetNamespace(), names)
+}
+
+func (r *EC2InstanceReconciler) ec2InstancesUsingProviderConfigs(
+	ctx context.Context, namespace string, providerConfigNames map[string]bool,
+) []ctrl.Request {
+	ec2Instances := &ec2instancev1alpha1.EC2InstanceList{}
+	if err := r.Client.List(ctx, ec2Instances, client.InNamespace(namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list EC2Instances")
+		return nil
+	}
+	var requests []ctrl.Request
+	for _, ec2Instance := range ec2Instances.Items {
+		if ref := ec2Instance.Spec.ProviderConfigRef; ref != nil && providerConfigNames[ref.Name] {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&ec2Instance)})
+		}
+	}
+	return requests
+}
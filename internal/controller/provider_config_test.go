@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	ec2instanceclient "github.com/kraken-iac/aws-ec2-instance/pkg/ec2instance_client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("getClientConfig", func() {
+	const namespace = "team-a"
+
+	var (
+		ctx         context.Context
+		r           *EC2InstanceReconciler
+		ec2Instance *v1alpha1.EC2Instance
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		providerConfig := &v1alpha1.ProviderConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "account-a", Namespace: namespace},
+			Spec: v1alpha1.ProviderConfigSpec{
+				CredentialsSecretRef: &corev1.LocalObjectReference{Name: "account-a-credentials"},
+				AssumeRole:           &v1alpha1.AssumeRole{RoleARN: "arn:aws:iam::123456789012:role/kraken", ExternalID: "team-a"},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "account-a-credentials", Namespace: namespace},
+			Data: map[string][]byte{
+				accessKeyIDSecretKey:     []byte("AKIAEXAMPLE"),
+				secretAccessKeySecretKey: []byte("secret"),
+			},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(providerConfig, secret).Build()
+		r = &EC2InstanceReconciler{
+			Client:    fakeClient,
+			Scheme:    scheme.Scheme,
+			APIReader: fakeClient,
+		}
+		ec2Instance = &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-ec2instance", Namespace: namespace},
+			Spec:       v1alpha1.EC2InstanceSpec{Region: "eu-west-1"},
+		}
+	})
+
+	It("should use the manager's credentials without a ProviderConfig", func() {
+		clientConfig, err := r.getClientConfig(ctx, ec2Instance)
+		Expect(err).Should(BeNil())
+		Expect(clientConfig.Region).Should(Equal("eu-west-1"))
+		Expect(clientConfig.ProviderConfig).Should(BeEmpty())
+		Expect(clientConfig.Options).Should(BeEmpty())
+	})
+
+	It("should resolve credentials and role from a ProviderConfig", func() {
+		ec2Instance.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "account-a"}
+
+		clientConfig, err := r.getClientConfig(ctx, ec2Instance)
+		Expect(err).Should(BeNil())
+		Expect(clientConfig.ProviderConfig).Should(Equal("team-a/account-a"))
+		Expect(clientConfig.Options).Should(HaveLen(2))
+	})
+
+	It("should fail when the ProviderConfig does not exist", func() {
+		ec2Instance.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "missing"}
+
+		_, err := r.getClientConfig(ctx, ec2Instance)
+		Expect(err).ShouldNot(BeNil())
+	})
+})
+
+// listingClient returns a fixed set of instances and records terminations
+type listingClient struct {
+	terminateRecordingClient
+	instances []types.Instance
+}
+
+func (c listingClient) GetInstances(ctx context.Context, filterOptions ec2instanceclient.FilterOptions) ([]types.Instance, error) {
+	return c.instances, nil
+}
+
+var _ = Describe("finalize", func() {
+	const namespace = "team-a"
+
+	var (
+		ctx         context.Context
+		r           *EC2InstanceReconciler
+		ec2Instance *v1alpha1.EC2Instance
+		req         ctrl.Request
+		recorder    *record.FakeRecorder
+		terminated  []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		terminated = nil
+		now := metav1.Now()
+		ec2Instance = &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "test-ec2instance",
+				Namespace:         namespace,
+				Finalizers:        []string{ec2InstanceFinalizer},
+				DeletionTimestamp: &now,
+			},
+			Spec: v1alpha1.EC2InstanceSpec{
+				ProviderConfigRef: &corev1.LocalObjectReference{Name: "deleted"},
+			},
+		}
+		req = ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ec2Instance)}
+		fakeClient := fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithObjects(ec2Instance).
+			WithStatusSubresource(ec2Instance).
+			Build()
+		recorder = record.NewFakeRecorder(10)
+		r = &EC2InstanceReconciler{
+			Client:    fakeClient,
+			Scheme:    scheme.Scheme,
+			Recorder:  recorder,
+			APIReader: fakeClient,
+			EC2InstanceClients: NewEC2InstanceClientPool(
+				"us-east-1",
+				func(ctx context.Context, region string, opts ...ec2instanceclient.Option) (EC2InstanceClient, error) {
+					return nil, errors.New("unexpected client creation")
+				},
+			),
+		}
+	})
+
+	It("should terminate instances with the last used client when the ProviderConfig is gone", func() {
+		r.EC2InstanceClients.SetLastUsed(req.NamespacedName, listingClient{
+			terminateRecordingClient: terminateRecordingClient{terminated: &terminated},
+			instances:                []types.Instance{{InstanceId: aws.String("i-1")}},
+		})
+
+		_, err := r.finalize(ctx, req, ec2Instance)
+		Expect(err).Should(BeNil())
+		Expect(terminated).Should(Equal([]string{"i-1"}))
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, req.NamespacedName, &v1alpha1.EC2Instance{}))).Should(BeTrue())
+		_, exists := r.EC2InstanceClients.LastUsed(req.NamespacedName)
+		Expect(exists).Should(BeFalse())
+	})
+
+	It("should keep the finalizer and retry when no client is available", func() {
+		result, err := r.finalize(ctx, req, ec2Instance)
+		Expect(err).Should(BeNil())
+		Expect(result.RequeueAfter).Should(Equal(credentialsRetryInterval))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("CredentialsUnavailable")))
+
+		updated := &v1alpha1.EC2Instance{}
+		Expect(r.Client.Get(ctx, req.NamespacedName, updated)).Should(Succeed())
+		Expect(updated.Finalizers).Should(ContainElement(ec2InstanceFinalizer))
+		Expect(meta.FindStatusCondition(updated.Status.Conditions, conditionTypeReady).Reason).
+			Should(Equal("CredentialsUnavailable"))
+	})
+})
+
+var _ = Describe("ProviderConfig watches", func() {
+	const namespace = "team-a"
+
+	var r *EC2InstanceReconciler
+
+	BeforeEach(func() {
+		usingA := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "uses-a", Namespace: namespace},
+			Spec:       v1alpha1.EC2InstanceSpec{ProviderConfigRef: &corev1.LocalObjectReference{Name: "account-a"}},
+		}
+		usingB := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "uses-b", Namespace: namespace},
+			Spec:       v1alpha1.EC2InstanceSpec{ProviderConfigRef: &corev1.LocalObjectReference{Name: "account-b"}},
+		}
+		defaultCredentials := &v1alpha1.EC2Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: "default-credentials", Namespace: namespace},
+		}
+		providerConfig := &v1alpha1.ProviderConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "account-a", Namespace: namespace},
+			Spec: v1alpha1.ProviderConfigSpec{
+				CredentialsSecretRef: &corev1.LocalObjectReference{Name: "account-a-credentials"},
+			},
+		}
+		r = &EC2InstanceReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(scheme.Scheme).
+				WithObjects(usingA, usingB, defaultCredentials, providerConfig).
+				Build(),
+		}
+	})
+
+	It("should reconcile the EC2Instances referencing a changed ProviderConfig", func() {
+		requests := r.ec2InstancesForProviderConfig(context.Background(), &v1alpha1.ProviderConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "account-a", Namespace: namespace},
+		})
+		Expect(requests).Should(Equal([]ctrl.Request{
+			{NamespacedName: client.ObjectKey{Name: "uses-a", Namespace: namespace}},
+		}))
+	})
+
+	It("should reconcile the EC2Instances whose credentials Secret changed", func() {
+		requests := r.ec2InstancesForSecret(context.Background(), &metav1.PartialObjectMetadata{
+			ObjectMeta: metav1.ObjectMeta{Name: "account-a-credentials", Namespace: namespace},
+		})
+		Expect(requests).Should(Equal([]ctrl.Request{
+			{NamespacedName: client.ObjectKey{Name: "uses-a", Namespace: namespace}},
+		}))
+		Expect(r.ec2InstancesForSecret(context.Background(), &metav1.PartialObjectMetadata{
+			ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: namespace},
+		})).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+const (
+	// resyncJitterFactor spreads resyncs of resources reconciled together
+	// by up to 10% of the resync period
+	resyncJitterFactor = 0.1
+)
+
+// resyncPeriod returns the resource's resync period, falling back to the
+// manager default
+func (r *EC2InstanceReconciler) resyncPeriod(spec ec2instancev1alpha1.EC2InstanceSpec) time.Duration {
+	if spec.ResyncPeriod != nil {
+		return spec.ResyncPeriod.Duration
+	}
+	return r.ResyncPeriod
+}
+
+// requeueAfter returns how long to wait before the next reconcile of a ready
+// resource: the jittered resync period, or sooner if a schedule boundary comes
+// first. Zero means the resource is not requeued.
+func requeueAfter(resyncPeriod time.Duration, nextScheduleTime time.Time, now time.Time) time.Duration {
+	var after time.Duration
+	if resyncPeriod > 0 {
+		after = wait.Jitter(resyncPeriod, resyncJitterFactor)
+	}
+	if !nextScheduleTime.IsZero() {
+		untilSchedule := nextScheduleTime.Sub(now)
+		if untilSchedule <= 0 {
+			untilSchedule = time.Second
+		}
+		if after == 0 || untilSchedule < after {
+			after = untilSchedule
+		}
+	}
+	return after
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("requeueAfter", func() {
+	now := time.Date(2024, time.July, 10, 12, 0, 0, 0, time.UTC)
+
+	It("should add up to 10% jitter to the resync period", func() {
+		after := requeueAfter(10*time.Minute, time.Time{}, now)
+		Expect(after).Should(BeNumerically(">=", 10*time.Minute))
+		Expect(after).Should(BeNumerically("<=", 11*time.Minute))
+	})
+
+	It("should requeue sooner when a schedule boundary comes first", func() {
+		Expect(requeueAfter(10*time.Minute, now.Add(time.Minute), now)).Should(Equal(time.Minute))
+	})
+
+	It("should not requeue when resyncs are disabled and there are no schedules", func() {
+		Expect(requeueAfter(0, time.Time{}, now)).Should(BeZero())
+	})
+})
+
+var _ = Describe("resyncPeriod", func() {
+	r := &EC2InstanceReconciler{ResyncPeriod: 10 * time.Minute}
+
+	It("should default to the manager's resync period", func() {
+		Expect(r.resyncPeriod(v1alpha1.EC2InstanceSpec{})).Should(Equal(10 * time.Minute))
+	})
+
+	It("should prefer the resource's resync period", func() {
+		spec := v1alpha1.EC2InstanceSpec{ResyncPeriod: &metav1.Duration{Duration: 0}}
+		Expect(r.resyncPeriod(spec)).Should(BeZero())
+	})
+})
@@ -0,0 +1,255 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+const (
+	// rolloutPollInterval is how often a rolling update is progressed while
+	// waiting for new instances to become available
+	rolloutPollInterval = 15 * time.Second
+)
+
+var defaultRollingUpdateLimit = intstr.FromString("25%")
+
+// rollingUpdate progresses a rolling update by one batch: out-of-date
+// instances are terminated and replacements launched within the bounds of
+// maxSurge and maxUnavailable. Replacements count towards availability once
+// they are running and passing status checks, which allows the next batch to
+// proceed on a later reconcile.
+func (r *EC2InstanceReconciler) rollingUpdate(
+	ctx context.Context,
+	ec2Client EC2InstanceClient,
+	ec2Instance *ec2instancev1alpha1.EC2Instance,
+	av *ec2InstanceApplicableValues,
+	instances []types.Instance,
+	outdated []types.Instance,
+	tags map[string]string,
+) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	statuses, err := ec2Client.GetInstanceStatuses(ctx, instances)
+	if err != nil {
+		log.Error(err, "Failed to retrieve EC2 instance statuses")
+		setFailedCondition(
+			ec2Instance,
+			metav1.Condition{
+				Type:    conditionTypeReady,
+				Status:  metav1.ConditionUnknown,
+				Reason:  "RetrievalFailed",
+				Message: "Failed to retrieve EC2 instance statuses",
+			},
+		)
+		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+	}
+
+	current := removeInstances(instances, outdated)
+	maxSurge, maxUnavailable := rollingUpdateLimits(ec2Instance.Spec.UpdateStrategy, av.maxCount)
+	plan := planRollingUpdate(outdated, current, availableInstanceIDs(statuses), av.maxCount, maxSurge, maxUnavailable)
+
+	if len(plan.terminate) > 0 {
+		log.Info("Terminating out-of-date EC2 instances", "instanceCount", len(plan.terminate))
+		if _, err := ec2Client.TerminateInstances(ctx, plan.terminate); err != nil {
+			log.Error(err, "Failed to terminate out-of-date EC2 instances")
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "TerminateFailed",
+					Message: "Failed to replace out-of-date EC2 instances",
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+	}
+
+	launchedCount := 0
+	if plan.launchCount > 0 {
+		clientToken, placements, err := r.reserveLaunch(
+			ctx, ec2Instance, planSubnetPlacements(current, av.subnetIDs, plan.launchCount, 1),
+		)
+		if err != nil {
+			log.Error(err, "Failed to record launch client token")
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Launching replacement EC2 instances", "instanceCount", plan.launchCount)
+		launchedIDs, err := launchInstances(ctx, ec2Client, av, placements, tags, clientToken)
+		launchedCount = len(launchedIDs)
+		if err != nil {
+			log.Error(err, "Failed to run instances")
+			recordFailedLaunch(&ec2Instance.Status, launchedIDs, err)
+			setStatusCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeInstancesLaunched,
+					Status:  metav1.ConditionFalse,
+					Reason:  "RunFailed",
+					Message: fmt.Sprintf("Failed to run instances: %s", err),
+				},
+			)
+			setFailedCondition(
+				ec2Instance,
+				metav1.Condition{
+					Type:    conditionTypeReady,
+					Status:  metav1.ConditionFalse,
+					Reason:  "RunFailed",
+					Message: "Failed to launch replacement EC2 instances",
+				},
+			)
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, ec2Instance)
+		}
+
+		// Replacements are tracked like any other launch so that those that
+		// never reach the running state are marked as failed and replaced
+		recordLaunch(&ec2Instance.Status, launchedIDs)
+	}
+
+	if len(plan.terminate) > 0 || launchedCount > 0 {
+		r.Recorder.Event(ec2Instance, "Normal", "RollingUpdate",
+			fmt.Sprintf("Replaced %d out-of-date instance(s) and launched %d instance(s)", len(plan.terminate), launchedCount),
+		)
+	}
+
+	updatedCount := len(current) + launchedCount
+	ec2Instance.Status.UpdatedInstances = int32(updatedCount)
+	ec2Instance.Status.AvailableInstances = int32(plan.availableCount)
+	setStatusCondition(
+		ec2Instance,
+		metav1.Condition{
+			Type:   conditionTypeProgressing,
+			Status: metav1.ConditionTrue,
+			Reason: "RollingUpdate",
+			Message: fmt.Sprintf("%d of %d instances updated, %d out of date",
+				updatedCount, av.maxCount, len(outdated)-len(plan.terminate)),
+		},
+	)
+	return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.Status().Update(ctx, ec2Instance)
+}
+
+type rolloutPlan struct {
+	terminate   []types.Instance
+	launchCount int
+
+	// availableCount is the number of available instances remaining after
+	// the terminations
+	availableCount int
+}
+
+// planRollingUpdate decides which out-of-date instances to terminate and how
+// many replacements to launch in a single step. The total number of
+// instances is kept at or below desired+maxSurge and the number of available
+// instances at or above desired-maxUnavailable.
+func planRollingUpdate(
+	outdated, current []types.Instance,
+	available map[string]bool,
+	desired, maxSurge, maxUnavailable int,
+) rolloutPlan {
+	plan := rolloutPlan{}
+	for _, inst := range append(append([]types.Instance{}, outdated...), current...) {
+		if available[aws.ToString(inst.InstanceId)] {
+			plan.availableCount++
+		}
+	}
+
+	// Unavailable instances can be terminated without reducing availability
+	for _, inst := range outdated {
+		if !available[aws.ToString(inst.InstanceId)] {
+			plan.terminate = append(plan.terminate, inst)
+		}
+	}
+	minAvailable := desired - maxUnavailable
+	for _, inst := range outdated {
+		if available[aws.ToString(inst.InstanceId)] && plan.availableCount > minAvailable {
+			plan.terminate = append(plan.terminate, inst)
+			plan.availableCount--
+		}
+	}
+
+	total := len(outdated) + len(current) - len(plan.terminate)
+	plan.launchCount = desired + maxSurge - total
+	if needed := desired - len(current); needed < plan.launchCount {
+		plan.launchCount = needed
+	}
+	if plan.launchCount < 0 {
+		plan.launchCount = 0
+	}
+	return plan
+}
+
+func updateStrategyType(strategy *ec2instancev1alpha1.UpdateStrategy) string {
+	if strategy == nil || strategy.Type == "" {
+		return ec2instancev1alpha1.RollingUpdateStrategyType
+	}
+	return strategy.Type
+}
+
+// rollingUpdateLimits resolves maxSurge and maxUnavailable against the
+// desired instance count. As with Deployments, maxUnavailable is raised to
+// one if both would otherwise be zero so that the update can progress.
+func rollingUpdateLimits(strategy *ec2instancev1alpha1.UpdateStrategy, desired int) (maxSurge, maxUnavailable int) {
+	surge, unavailable := &defaultRollingUpdateLimit, &defaultRollingUpdateLimit
+	if strategy != nil && strategy.RollingUpdate != nil {
+		if strategy.RollingUpdate.MaxSurge != nil {
+			surge = strategy.RollingUpdate.MaxSurge
+		}
+		if strategy.RollingUpdate.MaxUnavailable != nil {
+			unavailable = strategy.RollingUpdate.MaxUnavailable
+		}
+	}
+
+	// Values are validated by the webhook, so errors resolve to zero
+	maxSurge, _ = intstr.GetScaledValueFromIntOrPercent(surge, desired, true)
+	maxUnavailable, _ = intstr.GetScaledValueFromIntOrPercent(unavailable, desired, false)
+	if maxSurge == 0 && maxUnavailable == 0 {
+		maxUnavailable = 1
+	}
+	return maxSurge, maxUnavailable
+}
+
+// availableInstanceIDs returns the IDs of instances that are running and
+// passing both instance and system status checks
+func availableInstanceIDs(statuses []types.InstanceStatus) map[string]bool {
+	available := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		if status.InstanceState == nil || status.InstanceState.Name != types.InstanceStateNameRunning {
+			continue
+		}
+		if status.InstanceStatus == nil || status.InstanceStatus.Status != types.SummaryStatusOk {
+			continue
+		}
+		if status.SystemStatus == nil || status.SystemStatus.Status != types.SummaryStatusOk {
+			continue
+		}
+		available[aws.ToString(status.InstanceId)] = true
+	}
+	return available
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("planRollingUpdate", func() {
+	makeInstances := func(ids ...string) []types.Instance {
+		instances := make([]types.Instance, len(ids))
+		for i, id := range ids {
+			instances[i] = types.Instance{InstanceId: aws.String(id)}
+		}
+		return instances
+	}
+
+	It("should surge before terminating when no instances may be unavailable", func() {
+		outdated := makeInstances("old-1", "old-2", "old-3")
+		available := map[string]bool{"old-1": true, "old-2": true, "old-3": true}
+
+		plan := planRollingUpdate(outdated, nil, available, 3, 1, 0)
+		Expect(plan.terminate).Should(BeEmpty())
+		Expect(plan.launchCount).Should(Equal(1))
+	})
+
+	It("should terminate an available instance once its replacement is available", func() {
+		outdated := makeInstances("old-1", "old-2", "old-3")
+		current := makeInstances("new-1")
+		available := map[string]bool{"old-1": true, "old-2": true, "old-3": true, "new-1": true}
+
+		plan := planRollingUpdate(outdated, current, available, 3, 1, 0)
+		Expect(plan.terminate).Should(HaveLen(1))
+		Expect(plan.launchCount).Should(Equal(1))
+	})
+
+	It("should wait while replacements are not yet available", func() {
+		outdated := makeInstances("old-1", "old-2", "old-3")
+		current := makeInstances("new-1")
+		available := map[string]bool{"old-1": true, "old-2": true, "old-3": true}
+
+		plan := planRollingUpdate(outdated, current, available, 3, 1, 0)
+		Expect(plan.terminate).Should(BeEmpty())
+		Expect(plan.launchCount).Should(Equal(0))
+	})
+
+	It("should terminate then replace within maxUnavailable when surge is not allowed", func() {
+		outdated := makeInstances("old-1", "old-2", "old-3", "old-4")
+		available := map[string]bool{"old-1": true, "old-2": true, "old-3": true, "old-4": true}
+
+		plan := planRollingUpdate(outdated, nil, available, 4, 0, 2)
+		Expect(plan.terminate).Should(HaveLen(2))
+		Expect(plan.launchCount).Should(Equal(2))
+		Expect(plan.availableCount).Should(Equal(2))
+	})
+
+	It("should always terminate unavailable out-of-date instances", func() {
+		outdated := makeInstances("old-1", "old-2")
+		available := map[string]bool{"old-1": true}
+
+		plan := planRollingUpdate(outdated, nil, available, 2, 0, 0)
+		Expect(plan.terminate).Should(HaveLen(1))
+		Expect(*plan.terminate[0].InstanceId).Should(Equal("old-2"))
+	})
+})
+
+var _ = Describe("rollingUpdateLimits", func() {
+	It("should default to 25% surge rounded up and 25% unavailable rounded down", func() {
+		maxSurge, maxUnavailable := rollingUpdateLimits(nil, 6)
+		Expect(maxSurge).Should(Equal(2))
+		Expect(maxUnavailable).Should(Equal(1))
+	})
+
+	It("should allow one unavailable instance when both limits resolve to zero", func() {
+		zero := intstr.FromInt(0)
+		strategy := &v1alpha1.UpdateStrategy{
+			RollingUpdate: &v1alpha1.RollingUpdateStrategy{MaxSurge: &zero, MaxUnavailable: &zero},
+		}
+		maxSurge, maxUnavailable := rollingUpdateLimits(strategy, 3)
+		Expect(maxSurge).Should(Equal(0))
+		Expect(maxUnavailable).Should(Equal(1))
+	})
+})
+
+var _ = Describe("availableInstanceIDs", func() {
+	It("should only include running instances passing both status checks", func() {
+		ok := &types.InstanceStatusSummary{Status: types.SummaryStatusOk}
+		initializing := &types.InstanceStatusSummary{Status: types.SummaryStatusInitializing}
+		running := &types.InstanceState{Name: types.InstanceStateNameRunning}
+
+		available := availableInstanceIDs([]types.InstanceStatus{
+			{InstanceId: aws.String("i-1"), InstanceState: running, InstanceStatus: ok, SystemStatus: ok},
+			{InstanceId: aws.String("i-2"), InstanceState: running, InstanceStatus: initializing, SystemStatus: ok},
+			{InstanceId: aws.String("i-3"), InstanceState: &types.InstanceState{Name: types.InstanceStateNamePending}},
+		})
+		Expect(available).Should(Equal(map[string]bool{"i-1": true}))
+	})
+})
@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// scaleDownRank orders instances for termination when scaling down. Spot
+// instances that were interrupted go first, then instances that are not in
+// the desired power state, such as pending or stopped instances.
+func scaleDownRank(inst types.Instance, desiredState string) int {
+	if isSpotInterrupted(inst) {
+		return 0
+	}
+	if inst.State == nil || inst.State.Name != steadyInstanceState(desiredState) {
+		return 1
+	}
+	return 2
+}
+
+// selectScaleDownInstances chooses count instances to terminate. Instances
+// are chosen by scaleDownRank, then from the subnet holding the most
+// instances so that subnets stay balanced, then newest first.
+func selectScaleDownInstances(instances []types.Instance, count int, desiredState string) []types.Instance {
+	subnetCounts := make(map[string]int)
+	for _, inst := range instances {
+		if inst.SubnetId != nil {
+			subnetCounts[*inst.SubnetId]++
+		}
+	}
+	subnetCount := func(inst types.Instance) int {
+		if inst.SubnetId == nil {
+			return 0
+		}
+		return subnetCounts[*inst.SubnetId]
+	}
+	// better reports whether a should be terminated before b
+	better := func(a, b types.Instance) bool {
+		if rankA, rankB := scaleDownRank(a, desiredState), scaleDownRank(b, desiredState); rankA != rankB {
+			return rankA < rankB
+		}
+		if countA, countB := subnetCount(a), subnetCount(b); countA != countB {
+			return countA > countB
+		}
+		if a.LaunchTime != nil && b.LaunchTime != nil {
+			return a.LaunchTime.After(*b.LaunchTime)
+		}
+		return false
+	}
+
+	remaining := append([]types.Instance(nil), instances...)
+	var selected []types.Instance
+	for len(selected) < count && len(remaining) > 0 {
+		best := 0
+		for i := 1; i < len(remaining); i++ {
+			if better(remaining[i], remaining[best]) {
+				best = i
+			}
+		}
+		selected = append(selected, remaining[best])
+		if subnetID := remaining[best].SubnetId; subnetID != nil {
+			subnetCounts[*subnetID]--
+		}
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return selected
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("selectScaleDownInstances", func() {
+	instance := func(id, subnetID string, state types.InstanceStateName) types.Instance {
+		return types.Instance{
+			InstanceId: aws.String(id),
+			SubnetId:   aws.String(subnetID),
+			State:      &types.InstanceState{Name: state},
+		}
+	}
+	ids := func(instances []types.Instance) []string {
+		var ids []string
+		for _, inst := range instances {
+			ids = append(ids, aws.ToString(inst.InstanceId))
+		}
+		return ids
+	}
+
+	It("should terminate interrupted, pending and stopped instances first", func() {
+		interrupted := instance("i-4", "subnet-a", types.InstanceStateNameStopped)
+		interrupted.InstanceLifecycle = types.InstanceLifecycleTypeSpot
+		interrupted.StateReason = &types.StateReason{Code: aws.String(spotShutdownStateReason)}
+		instances := []types.Instance{
+			instance("i-1", "subnet-a", types.InstanceStateNameRunning),
+			instance("i-2", "subnet-a", types.InstanceStateNamePending),
+			instance("i-3", "subnet-a", types.InstanceStateNameRunning),
+			interrupted,
+		}
+
+		selected := selectScaleDownInstances(instances, 2, v1alpha1.DesiredStateRunning)
+		Expect(ids(selected)).Should(Equal([]string{"i-4", "i-2"}))
+	})
+
+	It("should keep stopped instances when they are the desired state", func() {
+		instances := []types.Instance{
+			instance("i-1", "subnet-a", types.InstanceStateNameStopped),
+			instance("i-2", "subnet-a", types.InstanceStateNameRunning),
+		}
+
+		selected := selectScaleDownInstances(instances, 1, v1alpha1.DesiredStateStopped)
+		Expect(ids(selected)).Should(Equal([]string{"i-2"}))
+	})
+
+	It("should keep subnets balanced", func() {
+		instances := []types.Instance{
+			instance("i-1", "subnet-a", types.InstanceStateNameRunning),
+			instance("i-2", "subnet-b", types.InstanceStateNameRunning),
+			instance("i-3", "subnet-b", types.InstanceStateNameRunning),
+			instance("i-4", "subnet-b", types.InstanceStateNameRunning),
+			instance("i-5", "subnet-c", types.InstanceStateNameRunning),
+			instance("i-6", "subnet-c", types.InstanceStateNameRunning),
+		}
+
+		selected := selectScaleDownInstances(instances, 3, v1alpha1.DesiredStateRunning)
+		Expect(ids(selected)).Should(ConsistOf("i-2", "i-3", "i-5"))
+	})
+
+	It("should terminate the newest instances among equals", func() {
+		older := instance("i-1", "subnet-a", types.InstanceStateNameRunning)
+		older.LaunchTime = aws.Time(time.Now().Add(-time.Hour))
+		newer := instance("i-2", "subnet-a", types.InstanceStateNameRunning)
+		newer.LaunchTime = aws.Time(time.Now())
+
+		selected := selectScaleDownInstances([]types.Instance{older, newer}, 1, v1alpha1.DesiredStateRunning)
+		Expect(ids(selected)).Should(Equal([]string{"i-2"}))
+	})
+})
@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// evaluateSchedules returns the first schedule whose window is open at now,
+// or nil if none are open, along with the time of the next window boundary
+// across all schedules. The next boundary is zero if there are no schedules.
+//
+// A window is open if the next time matching its end comes before the next
+// time matching its start, which avoids searching backwards for the most
+// recent start.
+func evaluateSchedules(
+	schedules []ec2instancev1alpha1.Schedule,
+	now time.Time,
+) (active *ec2instancev1alpha1.Schedule, nextBoundary time.Time, err error) {
+	for i := range schedules {
+		s := &schedules[i]
+		nextStart, nextEnd, err := nextScheduleBoundaries(*s, now)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("schedule %q: %w", s.Name, err)
+		}
+		if active == nil && nextEnd.Before(nextStart) {
+			active = s
+		}
+		for _, boundary := range []time.Time{nextStart, nextEnd} {
+			if !boundary.IsZero() && (nextBoundary.IsZero() || boundary.Before(nextBoundary)) {
+				nextBoundary = boundary
+			}
+		}
+	}
+	return active, nextBoundary, nil
+}
+
+func nextScheduleBoundaries(s ec2instancev1alpha1.Schedule, now time.Time) (nextStart, nextEnd time.Time, err error) {
+	loc := time.UTC
+	if s.TimeZone != "" {
+		if loc, err = time.LoadLocation(s.TimeZone); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+	start, err := cron.ParseStandard(s.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := cron.ParseStandard(s.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end: %w", err)
+	}
+	return start.Next(now.In(loc)), end.Next(now.In(loc)), nil
+}
+
+// applySchedule overrides the applicable counts and desired state with those
+// set on the active schedule
+func applySchedule(
+	schedule *ec2instancev1alpha1.Schedule,
+	av *ec2InstanceApplicableValues,
+	desiredState string,
+) string {
+	if schedule == nil {
+		return desiredState
+	}
+	if schedule.MaxCount != nil {
+		av.maxCount = *schedule.MaxCount
+	}
+	if schedule.MinCount != nil {
+		av.minCount = *schedule.MinCount
+	}
+	if av.minCount > av.maxCount {
+		av.minCount = av.maxCount
+	}
+	if schedule.DesiredState != "" {
+		return schedule.DesiredState
+	}
+	return desiredState
+}
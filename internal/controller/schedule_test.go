@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("evaluateSchedules", func() {
+	var (
+		zero   = 0
+		two    = 2
+		office v1alpha1.Schedule
+	)
+
+	BeforeEach(func() {
+		office = v1alpha1.Schedule{
+			Name:     "office-hours",
+			Start:    "0 8 * * 1-5",
+			End:      "0 18 * * 1-5",
+			TimeZone: "Europe/Dublin",
+			MaxCount: &two,
+		}
+	})
+
+	It("should apply a schedule while its window is open", func() {
+		// Wednesday 12:00 in Dublin (UTC+1 in summer)
+		now := time.Date(2024, time.July, 10, 11, 0, 0, 0, time.UTC)
+
+		active, next, err := evaluateSchedules([]v1alpha1.Schedule{office}, now)
+		Expect(err).Should(BeNil())
+		Expect(active).ShouldNot(BeNil())
+		Expect(active.Name).Should(Equal("office-hours"))
+		Expect(next).Should(BeTemporally("==", time.Date(2024, time.July, 10, 17, 0, 0, 0, time.UTC)))
+	})
+
+	It("should not apply a schedule outside its window", func() {
+		// Saturday
+		now := time.Date(2024, time.July, 13, 11, 0, 0, 0, time.UTC)
+
+		active, next, err := evaluateSchedules([]v1alpha1.Schedule{office}, now)
+		Expect(err).Should(BeNil())
+		Expect(active).Should(BeNil())
+		Expect(next).Should(BeTemporally("==", time.Date(2024, time.July, 15, 7, 0, 0, 0, time.UTC)))
+	})
+
+	It("should apply the first open schedule and requeue at the earliest boundary", func() {
+		overnight := v1alpha1.Schedule{
+			Name:     "overnight",
+			Start:    "0 20 * * *",
+			End:      "0 6 * * *",
+			MaxCount: &zero,
+		}
+		now := time.Date(2024, time.July, 10, 23, 0, 0, 0, time.UTC)
+
+		active, next, err := evaluateSchedules([]v1alpha1.Schedule{office, overnight}, now)
+		Expect(err).Should(BeNil())
+		Expect(active.Name).Should(Equal("overnight"))
+		Expect(next).Should(BeTemporally("==", time.Date(2024, time.July, 11, 6, 0, 0, 0, time.UTC)))
+	})
+
+	It("should fail on an invalid cron expression", func() {
+		office.Start = "not a cron expression"
+
+		_, _, err := evaluateSchedules([]v1alpha1.Schedule{office}, time.Now())
+		Expect(err).ShouldNot(BeNil())
+	})
+})
+
+var _ = Describe("applySchedule", func() {
+	It("should override counts and desired state", func() {
+		zero := 0
+		av := &ec2InstanceApplicableValues{maxCount: 3, minCount: 1}
+
+		desiredState := applySchedule(&v1alpha1.Schedule{MaxCount: &zero, DesiredState: v1alpha1.DesiredStateStopped}, av, v1alpha1.DesiredStateRunning)
+		Expect(av.maxCount).Should(Equal(0))
+		Expect(av.minCount).Should(Equal(0))
+		Expect(desiredState).Should(Equal(v1alpha1.DesiredStateStopped))
+	})
+
+	It("should leave values unchanged without an active schedule", func() {
+		av := &ec2InstanceApplicableValues{maxCount: 3, minCount: 1}
+
+		Expect(applySchedule(nil, av, v1alpha1.DesiredStateRunning)).Should(Equal(v1alpha1.DesiredStateRunning))
+		Expect(av.maxCount).Should(Equal(3))
+	})
+})
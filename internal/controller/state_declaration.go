@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	v1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	ec2instancev1alpha1 "github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+)
+
+// constructStateDeclarationData builds the EC2InstanceState published in the
+// StateDeclaration. The raw instance descriptions and spec are only included
+// if includeRaw is set.
+func constructStateDeclarationData(
+	ec2Instance ec2instancev1alpha1.EC2Instance,
+	instances []types.Instance,
+	region string,
+	includeRaw bool,
+) (*v1.JSON, error) {
+	state := ec2InstanceState(instances, region)
+	if includeRaw {
+		raw, err := json.Marshal(map[string]interface{}{
+			"instances": instances,
+			"spec":      ec2Instance.Spec,
+		})
+		if err != nil {
+			return nil, err
+		}
+		state.Raw = raw
+	}
+
+	dataJSON, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	stateDeclarationData := v1.JSON{}
+	stateDeclarationData.Raw = dataJSON
+	return &stateDeclarationData, nil
+}
+
+// ec2InstanceState converts instances to the published schema
+func ec2InstanceState(instances []types.Instance, region string) ec2instancev1alpha1.EC2InstanceState {
+	state := ec2instancev1alpha1.EC2InstanceState{
+		SchemaVersion:      ec2instancev1alpha1.EC2InstanceStateSchemaVersion,
+		Instances:          []ec2instancev1alpha1.InstanceState{},
+		InstanceIDs:        []string{},
+		InstanceARNs:       []string{},
+		PrivateIPAddresses: []string{},
+		PublicIPAddresses:  []string{},
+		PrivateDNSNames:    []string{},
+		PublicDNSNames:     []string{},
+		AvailabilityZones:  []string{},
+		SecurityGroupIDs:   []string{},
+	}
+	seenZones := make(map[string]bool)
+	seenGroups := make(map[string]bool)
+
+	appendIfSet := func(list []string, value string) []string {
+		if value == "" {
+			return list
+		}
+		return append(list, value)
+	}
+
+	for _, inst := range instances {
+		s := instanceState(inst, region)
+		state.Instances = append(state.Instances, s)
+		state.InstanceIDs = appendIfSet(state.InstanceIDs, s.InstanceID)
+		state.InstanceARNs = appendIfSet(state.InstanceARNs, s.ARN)
+		state.PrivateIPAddresses = appendIfSet(state.PrivateIPAddresses, s.PrivateIPAddress)
+		state.PublicIPAddresses = appendIfSet(state.PublicIPAddresses, s.PublicIPAddress)
+		state.PrivateDNSNames = appendIfSet(state.PrivateDNSNames, s.PrivateDNSName)
+		state.PublicDNSNames = appendIfSet(state.PublicDNSNames, s.PublicDNSName)
+		if s.AvailabilityZone != "" && !seenZones[s.AvailabilityZone] {
+			seenZones[s.AvailabilityZone] = true
+			state.AvailabilityZones = append(state.AvailabilityZones, s.AvailabilityZone)
+		}
+		for _, groupID := range s.SecurityGroupIDs {
+			if !seenGroups[groupID] {
+				seenGroups[groupID] = true
+				state.SecurityGroupIDs = append(state.SecurityGroupIDs, groupID)
+			}
+		}
+	}
+	return state
+}
+
+func instanceState(inst types.Instance, region string) ec2instancev1alpha1.InstanceState {
+	s := ec2instancev1alpha1.InstanceState{
+		InstanceID:       aws.ToString(inst.InstanceId),
+		ARN:              instanceARN(inst, region),
+		SubnetID:         aws.ToString(inst.SubnetId),
+		VPCID:            aws.ToString(inst.VpcId),
+		PrivateIPAddress: aws.ToString(inst.PrivateIpAddress),
+		PublicIPAddress:  aws.ToString(inst.PublicIpAddress),
+		PrivateDNSName:   aws.ToString(inst.PrivateDnsName),
+		PublicDNSName:    aws.ToString(inst.PublicDnsName),
+		ImageID:          aws.ToString(inst.ImageId),
+		InstanceType:     string(inst.InstanceType),
+	}
+	if inst.State != nil {
+		s.State = string(inst.State.Name)
+	}
+	if inst.Placement != nil {
+		s.AvailabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+	for _, group := range inst.SecurityGroups {
+		if group.GroupId != nil {
+			s.SecurityGroupIDs = append(s.SecurityGroupIDs, *group.GroupId)
+		}
+	}
+	return s
+}
+
+// instanceARN returns the ARN of an instance. DescribeInstances does not
+// return the owning account on the instance itself, so it is taken from the
+// instance's network interfaces. An empty string is returned if it is unknown.
+func instanceARN(inst types.Instance, region string) string {
+	if inst.InstanceId == nil || region == "" {
+		return ""
+	}
+	var accountID string
+	for _, networkInterface := range inst.NetworkInterfaces {
+		if networkInterface.OwnerId != nil {
+			accountID = *networkInterface.OwnerId
+			break
+		}
+	}
+	if accountID == "" {
+		return ""
+	}
+	return arn.ARN{
+		Partition: partitionForRegion(region),
+		Service:   "ec2",
+		Region:    region,
+		AccountID: accountID,
+		Resource:  "instance/" + *inst.InstanceId,
+	}.String()
+}
+
+// partitionForRegion returns the AWS partition a region belongs to
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	default:
+		return "aws"
+	}
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/kraken-iac/aws-ec2-instance/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("constructStateDeclarationData", func() {
+	instances := []types.Instance{
+		{
+			InstanceId:       aws.String("i-1"),
+			State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Placement:        &types.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			PrivateIpAddress: aws.String("10.0.0.1"),
+			PublicIpAddress:  aws.String("54.0.0.1"),
+			PrivateDnsName:   aws.String("ip-10-0-0-1.eu-west-1.compute.internal"),
+			SecurityGroups:   []types.GroupIdentifier{{GroupId: aws.String("sg-a")}, {GroupId: aws.String("sg-b")}},
+			NetworkInterfaces: []types.InstanceNetworkInterface{
+				{OwnerId: aws.String("123456789012")},
+			},
+		},
+		{
+			InstanceId:       aws.String("i-2"),
+			State:            &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Placement:        &types.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			PrivateIpAddress: aws.String("10.0.0.2"),
+			SecurityGroups:   []types.GroupIdentifier{{GroupId: aws.String("sg-a")}},
+		},
+	}
+
+	It("should publish the versioned schema with aggregate lists", func() {
+		data, err := constructStateDeclarationData(v1alpha1.EC2Instance{}, instances, "eu-west-1", false)
+		Expect(err).Should(BeNil())
+
+		var state v1alpha1.EC2InstanceState
+		Expect(json.Unmarshal(data.Raw, &state)).Should(Succeed())
+		Expect(state.SchemaVersion).Should(Equal(v1alpha1.EC2InstanceStateSchemaVersion))
+		Expect(state.Instances).Should(HaveLen(2))
+		Expect(state.Instances[0].ARN).Should(Equal("arn:aws:ec2:eu-west-1:123456789012:instance/i-1"))
+		Expect(state.Instances[1].ARN).Should(BeEmpty())
+		Expect(state.InstanceIDs).Should(Equal([]string{"i-1", "i-2"}))
+		Expect(state.InstanceARNs).Should(Equal([]string{"arn:aws:ec2:eu-west-1:123456789012:instance/i-1"}))
+		Expect(state.PrivateIPAddresses).Should(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+		Expect(state.PublicIPAddresses).Should(Equal([]string{"54.0.0.1"}))
+		Expect(state.AvailabilityZones).Should(Equal([]string{"eu-west-1a"}))
+		Expect(state.SecurityGroupIDs).Should(Equal([]string{"sg-a", "sg-b"}))
+		Expect(state.Raw).Should(BeEmpty())
+	})
+
+	It("should publish empty lists when there are no instances", func() {
+		data, err := constructStateDeclarationData(v1alpha1.EC2Instance{}, nil, "eu-west-1", false)
+		Expect(err).Should(BeNil())
+		Expect(string(data.Raw)).Should(ContainSubstring(`"instanceIDs":[]`))
+	})
+
+	It("should only include the raw dump when enabled", func() {
+		data, err := constructStateDeclarationData(v1alpha1.EC2Instance{}, instances, "eu-west-1", true)
+		Expect(err).Should(BeNil())
+
+		var state v1alpha1.EC2InstanceState
+		Expect(json.Unmarshal(data.Raw, &state)).Should(Succeed())
+		var raw map[string]json.RawMessage
+		Expect(json.Unmarshal(state.Raw, &raw)).Should(Succeed())
+		Expect(raw).Should(HaveKey("instances"))
+		Expect(raw).Should(HaveKey("spec"))
+	})
+
+	It("should use the region's partition in ARNs", func() {
+		Expect(partitionForRegion("cn-north-1")).Should(Equal("aws-cn"))
+		Expect(partitionForRegion("us-gov-west-1")).Should(Equal("aws-us-gov"))
+		Expect(partitionForRegion("us-east-1")).Should(Equal("aws"))
+	})
+})
@@ -2,36 +2,106 @@ package ec2instanceclient
 
 import (
 	"context"
-	"time"
+	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/config"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 )
 
 type ec2InstanceClient struct {
 	ec2Client *ec2.Client
 }
 
-func New(ctx context.Context, region string) (*ec2InstanceClient, error) {
-	sdkConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+func New(ctx context.Context, region string, opts ...Option) (*ec2InstanceClient, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	loadOptions := append([]func(*config.LoadOptions) error{config.WithRegion(region)}, o.loadOptions...)
+	sdkConfig, err := config.LoadDefaultConfig(ctx, loadOptions...)
 	if err != nil {
 		return nil, err
 	}
+	if o.assumeRole != nil {
+		sdkConfig.Credentials = o.assumeRole.credentialsProvider(sdkConfig)
+	}
 	client := ec2InstanceClient{
-		ec2Client: ec2.NewFromConfig(sdkConfig),
+		ec2Client: ec2.NewFromConfig(sdkConfig, o.ec2Options),
 	}
 	return &client, nil
 }
 
 type RunInstancesInput struct {
-	MaxCount     int
-	MinCount     int
-	ImageID      string
-	InstanceType string
-	Tags         map[string]string
+	MaxCount         int
+	MinCount         int
+	ImageID          string
+	InstanceType     string
+	LaunchTemplate   *LaunchTemplateSpecification
+	SubnetID         string
+	SecurityGroupIDs []string
+	KeyName          string
+	// IAMInstanceProfile may be either an instance profile ARN or name
+	IAMInstanceProfile string
+	SpotOptions        *SpotOptions
+	MetadataOptions    *MetadataOptions
+	RootVolume         *EBSVolume
+	DataVolumes        []BlockDeviceMapping
+	Hibernation        bool
+	Tags               map[string]string
+
+	// ClientToken makes the launch idempotent. Retrying a launch with the
+	// same token returns the instances launched by the original request.
+	ClientToken string
+
+	// UserData must already be base64-encoded
+	UserData string
+}
+
+// LaunchTemplateSpecification identifies a launch template by ID or name.
+// Version may be $Latest, $Default or a version number.
+type LaunchTemplateSpecification struct {
+	ID      string
+	Name    string
+	Version string
+}
+
+// SpotOptions launches instances as spot instances when set. Empty fields
+// use the EC2 defaults.
+type SpotOptions struct {
+	MaxPrice             string
+	InterruptionBehavior string
+	RequestType          string
+}
+
+// MetadataOptions configures the instance metadata service. Empty fields use
+// the EC2 defaults.
+type MetadataOptions struct {
+	HTTPTokens              string
+	HTTPPutResponseHopLimit *int32
+	HTTPEndpoint            string
+	InstanceMetadataTags    string
+}
+
+type EBSVolume struct {
+	SizeGiB             *int32
+	VolumeType          string
+	IOPS                *int32
+	Throughput          *int32
+	Encrypted           *bool
+	KMSKeyID            string
+	DeleteOnTermination *bool
+}
+
+type BlockDeviceMapping struct {
+	DeviceName string
+	EBSVolume
 }
 
 func (c ec2InstanceClient) RunInstances(ctx context.Context, params *RunInstancesInput) (*ec2.RunInstancesOutput, error) {
@@ -43,20 +113,154 @@ func (c ec2InstanceClient) RunInstances(ctx context.Context, params *RunInstance
 		},
 	}
 
-	output, err := c.ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
+	input := &ec2.RunInstancesInput{
 		MaxCount:          aws.Int32(int32(params.MaxCount)),
 		MinCount:          aws.Int32(int32(params.MinCount)),
-		ImageId:           aws.String(params.ImageID),
-		InstanceType:      types.InstanceType(params.InstanceType),
 		TagSpecifications: tagSpecs,
-	})
+	}
+	// Image ID and instance type may be provided by a launch template
+	if params.ImageID != "" {
+		input.ImageId = aws.String(params.ImageID)
+	}
+	if params.InstanceType != "" {
+		input.InstanceType = types.InstanceType(params.InstanceType)
+	}
+	if params.LaunchTemplate != nil {
+		input.LaunchTemplate = params.LaunchTemplate.toLaunchTemplateSpecification()
+	}
+	if params.SubnetID != "" {
+		input.SubnetId = aws.String(params.SubnetID)
+	}
+	if params.UserData != "" {
+		input.UserData = aws.String(params.UserData)
+	}
+	if len(params.SecurityGroupIDs) > 0 {
+		input.SecurityGroupIds = params.SecurityGroupIDs
+	}
+	if params.KeyName != "" {
+		input.KeyName = aws.String(params.KeyName)
+	}
+	if params.IAMInstanceProfile != "" {
+		input.IamInstanceProfile = toIamInstanceProfileSpecification(params.IAMInstanceProfile)
+	}
+	if params.ClientToken != "" {
+		input.ClientToken = aws.String(params.ClientToken)
+	}
+	if params.Hibernation {
+		input.HibernationOptions = &types.HibernationOptionsRequest{Configured: aws.Bool(true)}
+	}
+	if params.SpotOptions != nil {
+		input.InstanceMarketOptions = params.SpotOptions.toInstanceMarketOptions()
+	}
+	if params.MetadataOptions != nil {
+		input.MetadataOptions = params.MetadataOptions.toInstanceMetadataOptionsRequest()
+	}
+	if params.RootVolume != nil {
+		// The root device name differs between AMIs so must be looked up
+		rootDeviceName, err := c.getRootDeviceName(ctx, params.ImageID)
+		if err != nil {
+			return nil, err
+		}
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, BlockDeviceMapping{
+			DeviceName: rootDeviceName,
+			EBSVolume:  *params.RootVolume,
+		}.toBlockDeviceMapping())
+	}
+	for _, dataVolume := range params.DataVolumes {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, dataVolume.toBlockDeviceMapping())
+	}
+
+	output, err := c.ec2Client.RunInstances(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 	return output, nil
 }
 
+func (c ec2InstanceClient) getRootDeviceName(ctx context.Context, imageID string) (string, error) {
+	if imageID == "" {
+		return "", fmt.Errorf("an image ID is required to determine the root device name")
+	}
+	output, err := c.ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(output.Images) == 0 || output.Images[0].RootDeviceName == nil {
+		return "", fmt.Errorf("could not determine root device name for image %s", imageID)
+	}
+	return *output.Images[0].RootDeviceName, nil
+}
+
+func (s LaunchTemplateSpecification) toLaunchTemplateSpecification() *types.LaunchTemplateSpecification {
+	spec := &types.LaunchTemplateSpecification{}
+	if s.ID != "" {
+		spec.LaunchTemplateId = aws.String(s.ID)
+	}
+	if s.Name != "" {
+		spec.LaunchTemplateName = aws.String(s.Name)
+	}
+	if s.Version != "" {
+		spec.Version = aws.String(s.Version)
+	}
+	return spec
+}
+
+func (s SpotOptions) toInstanceMarketOptions() *types.InstanceMarketOptionsRequest {
+	spotOptions := &types.SpotMarketOptions{
+		InstanceInterruptionBehavior: types.InstanceInterruptionBehavior(s.InterruptionBehavior),
+		SpotInstanceType:             types.SpotInstanceType(s.RequestType),
+	}
+	if s.MaxPrice != "" {
+		spotOptions.MaxPrice = aws.String(s.MaxPrice)
+	}
+	return &types.InstanceMarketOptionsRequest{
+		MarketType:  types.MarketTypeSpot,
+		SpotOptions: spotOptions,
+	}
+}
+
+func (m MetadataOptions) toInstanceMetadataOptionsRequest() *types.InstanceMetadataOptionsRequest {
+	return &types.InstanceMetadataOptionsRequest{
+		HttpTokens:              types.HttpTokensState(m.HTTPTokens),
+		HttpPutResponseHopLimit: m.HTTPPutResponseHopLimit,
+		HttpEndpoint:            types.InstanceMetadataEndpointState(m.HTTPEndpoint),
+		InstanceMetadataTags:    types.InstanceMetadataTagsState(m.InstanceMetadataTags),
+	}
+}
+
+func toIamInstanceProfileSpecification(profile string) *types.IamInstanceProfileSpecification {
+	if arn.IsARN(profile) {
+		return &types.IamInstanceProfileSpecification{Arn: aws.String(profile)}
+	}
+	return &types.IamInstanceProfileSpecification{Name: aws.String(profile)}
+}
+
+func (m BlockDeviceMapping) toBlockDeviceMapping() types.BlockDeviceMapping {
+	ebs := &types.EbsBlockDevice{
+		VolumeSize:          m.SizeGiB,
+		VolumeType:          types.VolumeType(m.VolumeType),
+		Iops:                m.IOPS,
+		Throughput:          m.Throughput,
+		Encrypted:           m.Encrypted,
+		DeleteOnTermination: m.DeleteOnTermination,
+	}
+	if m.KMSKeyID != "" {
+		ebs.KmsKeyId = aws.String(m.KMSKeyID)
+	}
+	return types.BlockDeviceMapping{
+		DeviceName: aws.String(m.DeviceName),
+		Ebs:        ebs,
+	}
+}
+
 type FilterOptions struct {
+	// InstanceIDs limits the results to the given instances. Instances that
+	// are not found, for example because they are not yet visible after
+	// being launched, are omitted rather than returning an error.
+	InstanceIDs []string
+
 	MatchTags   map[string]string
 	MatchStates []types.InstanceStateName
 }
@@ -85,8 +289,32 @@ func (f FilterOptions) toFilters() []types.Filter {
 func (c ec2InstanceClient) GetInstances(ctx context.Context, filterOptions FilterOptions) ([]types.Instance, error) {
 	filters := filterOptions.toFilters()
 	describeInstancesInput := constructDescribeInstancesInput(filters)
+	describeInstancesInput.InstanceIds = filterOptions.InstanceIDs
+
+	instances, err := c.describeInstances(ctx, &describeInstancesInput)
+	if !isInstanceNotFound(err) || len(filterOptions.InstanceIDs) == 0 {
+		return instances, err
+	}
 
-	describeInstancesoutput, err := c.ec2Client.DescribeInstances(ctx, &describeInstancesInput)
+	// The request fails if any instance is not found, so describe each
+	// instance separately to return those that were found
+	instances = nil
+	for _, id := range filterOptions.InstanceIDs {
+		describeInstancesInput.InstanceIds = []string{id}
+		found, err := c.describeInstances(ctx, &describeInstancesInput)
+		if isInstanceNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, found...)
+	}
+	return instances, nil
+}
+
+func (c ec2InstanceClient) describeInstances(ctx context.Context, input *ec2.DescribeInstancesInput) ([]types.Instance, error) {
+	describeInstancesoutput, err := c.ec2Client.DescribeInstances(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -98,12 +326,22 @@ func (c ec2InstanceClient) GetInstances(ctx context.Context, filterOptions Filte
 	return instances, nil
 }
 
-func (c ec2InstanceClient) WaitUntilRunning(ctx context.Context, filterOptions FilterOptions, duration time.Duration) error {
-	filters := filterOptions.toFilters()
-	describeInstancesInput := constructDescribeInstancesInput(filters)
+// isInstanceNotFound reports whether err is returned because an instance ID
+// does not exist
+func isInstanceNotFound(err error) bool {
+	return hasErrorCode(err, "InvalidInstanceID.NotFound")
+}
+
+// IsIdempotentParameterMismatch reports whether err is returned because a
+// client token was reused with different launch parameters. Retrying with
+// the same token can never succeed.
+func IsIdempotentParameterMismatch(err error) bool {
+	return hasErrorCode(err, "IdempotentParameterMismatch")
+}
 
-	waiter := ec2.NewInstanceRunningWaiter(c.ec2Client)
-	return waiter.Wait(ctx, &describeInstancesInput, *aws.Duration(duration))
+func hasErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
 }
 
 func (c ec2InstanceClient) TerminateInstances(ctx context.Context, instances []types.Instance) (*ec2.TerminateInstancesOutput, error) {
@@ -115,6 +353,70 @@ func (c ec2InstanceClient) TerminateInstances(ctx context.Context, instances []t
 	return o, err
 }
 
+// StopInstances stops the given instances, hibernating them if hibernate is
+// true. Hibernation must have been enabled when the instances were launched.
+func (c ec2InstanceClient) StopInstances(ctx context.Context, instances []types.Instance, hibernate bool) (*ec2.StopInstancesOutput, error) {
+	return c.ec2Client.StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: toInstanceIds(instances),
+		Hibernate:   aws.Bool(hibernate),
+	})
+}
+
+func (c ec2InstanceClient) StartInstances(ctx context.Context, instances []types.Instance) (*ec2.StartInstancesOutput, error) {
+	return c.ec2Client.StartInstances(ctx, &ec2.StartInstancesInput{InstanceIds: toInstanceIds(instances)})
+}
+
+// ModifyInstanceAttributeInput changes a single attribute of an instance.
+// The instance must be stopped to change its type.
+type ModifyInstanceAttributeInput struct {
+	InstanceID   string
+	InstanceType string
+}
+
+func (c ec2InstanceClient) ModifyInstanceAttribute(ctx context.Context, params *ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	input := &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(params.InstanceID),
+	}
+	if params.InstanceType != "" {
+		input.InstanceType = &types.AttributeValue{Value: aws.String(params.InstanceType)}
+	}
+	return c.ec2Client.ModifyInstanceAttribute(ctx, input)
+}
+
+// GetInstanceStatuses returns the status checks of the given instances,
+// including those that are not running
+func (c ec2InstanceClient) GetInstanceStatuses(ctx context.Context, instances []types.Instance) ([]types.InstanceStatus, error) {
+	// An empty list of IDs would describe every instance in the account
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	o, err := c.ec2Client.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         toInstanceIds(instances),
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.InstanceStatuses, nil
+}
+
+// CreateTags adds or overwrites the given tags on the instances
+func (c ec2InstanceClient) CreateTags(ctx context.Context, instances []types.Instance, tags map[string]string) error {
+	_, err := c.ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: toInstanceIds(instances),
+		Tags:      mapToTags(tags),
+	})
+	return err
+}
+
+func toInstanceIds(instances []types.Instance) []string {
+	instanceIds := make([]string, len(instances))
+	for i, inst := range instances {
+		instanceIds[i] = *inst.InstanceId
+	}
+	return instanceIds
+}
+
 func mapToTags(m map[string]string) []types.Tag {
 	tags := make([]types.Tag, len(m))
 	i := 0
@@ -0,0 +1,94 @@
+package ec2instanceclient
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Option configures the client created by New
+type Option func(*options)
+
+type options struct {
+	loadOptions []func(*config.LoadOptions) error
+	assumeRole  *assumeRoleOptions
+	endpointURL string
+}
+
+type assumeRoleOptions struct {
+	roleARN     string
+	externalID  string
+	sessionName string
+}
+
+// WithStaticCredentials uses the given credentials instead of those found in
+// the default credential chain
+func WithStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) Option {
+	return func(o *options) {
+		o.loadOptions = append(o.loadOptions, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken),
+		))
+	}
+}
+
+// WithAssumeRole assumes the given role using the otherwise configured
+// credentials. externalID and sessionName may be empty.
+func WithAssumeRole(roleARN, externalID, sessionName string) Option {
+	return func(o *options) {
+		o.assumeRole = &assumeRoleOptions{
+			roleARN:     roleARN,
+			externalID:  externalID,
+			sessionName: sessionName,
+		}
+	}
+}
+
+// WithEndpointURL sends EC2 requests to the given URL instead of the regional
+// AWS endpoint, e.g. for LocalStack or another EC2-compatible emulator
+func WithEndpointURL(url string) Option {
+	return func(o *options) {
+		o.endpointURL = url
+	}
+}
+
+// WithRetryMaxAttempts sets the maximum number of attempts per request
+func WithRetryMaxAttempts(maxAttempts int) Option {
+	return func(o *options) {
+		o.loadOptions = append(o.loadOptions, config.WithRetryMaxAttempts(maxAttempts))
+	}
+}
+
+// WithRetryMode sets the retry mode, either standard or adaptive
+func WithRetryMode(mode aws.RetryMode) Option {
+	return func(o *options) {
+		o.loadOptions = append(o.loadOptions, config.WithRetryMode(mode))
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send requests
+func WithHTTPClient(httpClient config.HTTPClient) Option {
+	return func(o *options) {
+		o.loadOptions = append(o.loadOptions, config.WithHTTPClient(httpClient))
+	}
+}
+
+func (o options) ec2Options(eo *ec2.Options) {
+	if o.endpointURL != "" {
+		eo.BaseEndpoint = aws.String(o.endpointURL)
+	}
+}
+
+func (o assumeRoleOptions) credentialsProvider(cfg aws.Config) aws.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), o.roleARN, func(aro *stscreds.AssumeRoleOptions) {
+		if o.externalID != "" {
+			aro.ExternalID = aws.String(o.externalID)
+		}
+		if o.sessionName != "" {
+			aro.RoleSessionName = o.sessionName
+		}
+	})
+	return aws.NewCredentialsCache(provider)
+}
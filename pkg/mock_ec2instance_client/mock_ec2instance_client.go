@@ -2,7 +2,6 @@ package mockec2instanceclient
 
 import (
 	"context"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
@@ -19,6 +18,13 @@ func (c MockEC2InstanceClient) RunInstances(ctx context.Context, params *ec2inst
 		inst := ec2types.Instance{
 			ImageId:      &params.ImageID,
 			InstanceType: ec2types.InstanceTypeT2Nano,
+			State:        &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+		}
+		if params.SubnetID != "" {
+			inst.SubnetId = &params.SubnetID
+		}
+		for j := range params.SecurityGroupIDs {
+			inst.SecurityGroups = append(inst.SecurityGroups, ec2types.GroupIdentifier{GroupId: &params.SecurityGroupIDs[j]})
 		}
 		newInstances[i] = inst
 	}
@@ -33,15 +39,40 @@ func (c MockEC2InstanceClient) GetInstances(ctx context.Context, filterOptions e
 	return c.instances, nil
 }
 
-func (c MockEC2InstanceClient) WaitUntilRunning(ctx context.Context, filterOptions ec2instanceclient.FilterOptions, duration time.Duration) error {
-	return nil
-}
-
 func (c MockEC2InstanceClient) TerminateInstances(ctx context.Context, instances []ec2types.Instance) (*ec2.TerminateInstancesOutput, error) {
 	c.deleteAllInstances()
 	return &ec2.TerminateInstancesOutput{}, nil
 }
 
+func (c MockEC2InstanceClient) StopInstances(ctx context.Context, instances []ec2types.Instance, hibernate bool) (*ec2.StopInstancesOutput, error) {
+	return &ec2.StopInstancesOutput{}, nil
+}
+
+func (c MockEC2InstanceClient) StartInstances(ctx context.Context, instances []ec2types.Instance) (*ec2.StartInstancesOutput, error) {
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+func (c MockEC2InstanceClient) ModifyInstanceAttribute(ctx context.Context, params *ec2instanceclient.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (c MockEC2InstanceClient) GetInstanceStatuses(ctx context.Context, instances []ec2types.Instance) ([]ec2types.InstanceStatus, error) {
+	statuses := make([]ec2types.InstanceStatus, len(instances))
+	for i, inst := range instances {
+		statuses[i] = ec2types.InstanceStatus{
+			InstanceId:     inst.InstanceId,
+			InstanceState:  &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+			InstanceStatus: &ec2types.InstanceStatusSummary{Status: ec2types.SummaryStatusOk},
+			SystemStatus:   &ec2types.InstanceStatusSummary{Status: ec2types.SummaryStatusOk},
+		}
+	}
+	return statuses, nil
+}
+
+func (c MockEC2InstanceClient) CreateTags(ctx context.Context, instances []ec2types.Instance, tags map[string]string) error {
+	return nil
+}
+
 func (c *MockEC2InstanceClient) appendInstances(instances []ec2types.Instance) {
 	c.instances = append(c.instances, instances...)
 }